@@ -0,0 +1,174 @@
+package main
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// validateZip opens path as a zip archive and reads every member, so a
+// truncated file or a bad CRC is caught before release. It stops between
+// members once ctx is done.
+func validateZip(ctx context.Context, path string) error {
+	zr, err := zip.OpenReader(path)
+	if err != nil {
+		return fmt.Errorf("%s: %w", filepath.Base(path), err)
+	}
+	defer zr.Close()
+	if len(zr.File) == 0 {
+		return fmt.Errorf("%s: archive is empty", filepath.Base(path))
+	}
+	for _, f := range zr.File {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		rc, err := f.Open()
+		if err != nil {
+			return fmt.Errorf("%s: %s: %w", filepath.Base(path), f.Name, err)
+		}
+		_, err = io.Copy(io.Discard, rc)
+		rc.Close()
+		if err != nil {
+			return fmt.Errorf("%s: %s: %w", filepath.Base(path), f.Name, err)
+		}
+	}
+	return nil
+}
+
+// validateArchives checks every file in versionDir with validateZip, up to
+// jobs at a time. The first failure stops the checks that have not
+// finished; every failure found by then is reported, in file order.
+func validateArchives(ctx context.Context, versionDir string, files []string, jobs int) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	errs := make([]error, len(files))
+	sem := make(chan struct{}, max(jobs, 1))
+	var wg sync.WaitGroup
+	for i, f := range files {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			sem <- struct{}{}
+			defer func() { <-sem }()
+			if ctx.Err() != nil {
+				return
+			}
+			if err := validateZip(ctx, filepath.Join(versionDir, f)); err != nil {
+				errs[i] = err
+				cancel()
+			}
+		}()
+	}
+	wg.Wait()
+
+	var failed []error
+	for _, err := range errs {
+		// checks cut short by another failure say nothing about their file
+		if err != nil && !errors.Is(err, context.Canceled) {
+			failed = append(failed, err)
+		}
+	}
+	if len(failed) == 0 {
+		// nil, unless the caller gave up
+		return context.Cause(ctx)
+	}
+	return errors.Join(failed...)
+}
+
+// extractInnerZips unpacks the .zip members of the zip or tar file
+// archive into dir, flattened to their base names, and returns how many it
+// wrote. Other members are skipped.
+func extractInnerZips(ctx context.Context, archive, dir string) (int, error) {
+	name := strings.ToLower(archive)
+	switch {
+	case strings.HasSuffix(name, ".zip"):
+		if err := validateZip(ctx, archive); err != nil {
+			return 0, err
+		}
+		zr, err := zip.OpenReader(archive)
+		if err != nil {
+			return 0, err
+		}
+		defer zr.Close()
+		n := 0
+		for _, f := range zr.File {
+			if f.FileInfo().IsDir() || !isZipName(f.Name) {
+				continue
+			}
+			rc, err := f.Open()
+			if err != nil {
+				return n, fmt.Errorf("%s: %w", f.Name, err)
+			}
+			err = writeMember(dir, f.Name, rc)
+			rc.Close()
+			if err != nil {
+				return n, err
+			}
+			n++
+		}
+		return n, nil
+	case strings.HasSuffix(name, ".tar"), strings.HasSuffix(name, ".tar.gz"), strings.HasSuffix(name, ".tgz"):
+		fh, err := os.Open(archive)
+		if err != nil {
+			return 0, err
+		}
+		defer fh.Close()
+		var r io.Reader = fh
+		if !strings.HasSuffix(name, ".tar") {
+			gz, err := gzip.NewReader(fh)
+			if err != nil {
+				return 0, fmt.Errorf("%s: %w", filepath.Base(archive), err)
+			}
+			defer gz.Close()
+			r = gz
+		}
+		tr := tar.NewReader(r)
+		n := 0
+		for {
+			hdr, err := tr.Next()
+			if err == io.EOF {
+				return n, nil
+			}
+			if err != nil {
+				return n, fmt.Errorf("%s: %w", filepath.Base(archive), err)
+			}
+			if hdr.Typeflag != tar.TypeReg || !isZipName(hdr.Name) {
+				continue
+			}
+			if err := writeMember(dir, hdr.Name, tr); err != nil {
+				return n, err
+			}
+			n++
+		}
+	default:
+		return 0, fmt.Errorf("%s: unsupported archive type (want .zip, .tar, .tar.gz or .tgz)", filepath.Base(archive))
+	}
+}
+
+func isZipName(name string) bool {
+	return strings.HasSuffix(strings.ToLower(name), ".zip")
+}
+
+// writeMember copies r to dir under the base name of member; two members
+// with the same base name are an error rather than a silent overwrite.
+func writeMember(dir, member string, r io.Reader) error {
+	dst := filepath.Join(dir, path.Base(member))
+	out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+	if err != nil {
+		return fmt.Errorf("%s: %w", member, err)
+	}
+	if _, err := io.Copy(out, r); err != nil {
+		out.Close()
+		return fmt.Errorf("%s: %w", member, err)
+	}
+	return out.Close()
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"archive/tar"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+
+	"relayUpdater/release"
+)
+
+// bundleName is the archive -bundle-upload unpacks in the remote version
+// directory. The leading dot keeps it out of casual listings while it
+// exists.
+const bundleName = ".relay-bundle.tar"
+
+// uploadBundle tars localFiles into one archive, uploads it into
+// remoteVersionDir, unpacks it there and removes it, then checks that every
+// file arrived. One transfer replaces one per file.
+func uploadBundle(ctx context.Context, remote release.Transport, remoteVersionDir string, localFiles []string, metrics *releaseMetrics) error {
+	tmp, err := os.MkdirTemp("", "relay-bundle-")
+	if err != nil {
+		return fmt.Errorf("failed to create temp dir: %w", err)
+	}
+	defer os.RemoveAll(tmp)
+	bundle := filepath.Join(tmp, bundleName)
+	if err := writeBundle(bundle, localFiles); err != nil {
+		return fmt.Errorf("failed to write bundle: %w", err)
+	}
+
+	if err := remote.Upload(ctx, remoteVersionDir, bundle); err != nil {
+		return fmt.Errorf("upload bundle failed: %w", err)
+	}
+	metrics.addUploaded(bundle)
+	remoteBundle := remoteVersionDir + "/" + bundleName
+	// -m: mtimes are extraction time, as with any other upload; the bundle
+	// goes whether or not it unpacked
+	cmd := fmt.Sprintf("tar -xmf %s -C %s; s=$?; rm -f %s; exit $s",
+		release.ShellQuote(remoteBundle), release.ShellQuote(remoteVersionDir), release.ShellQuote(remoteBundle))
+	if err := remote.Run(ctx, cmd); err != nil {
+		return fmt.Errorf("unpacking bundle in %s failed: %w", remoteVersionDir, err)
+	}
+
+	var paths []string
+	for _, f := range localFiles {
+		paths = append(paths, remoteVersionDir+"/"+filepath.Base(f))
+	}
+	if err := checkUploaded(ctx, remote, paths); err != nil {
+		return fmt.Errorf("after unpacking the bundle: %w", err)
+	}
+	return nil
+}
+
+// writeBundle writes an uncompressed tar of files, by base name, to dst.
+// The artifacts are zips already, so compressing again gains nothing.
+func writeBundle(dst string, files []string) error {
+	out, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+	tw := tar.NewWriter(out)
+	for _, f := range files {
+		if err := addToBundle(tw, f); err != nil {
+			out.Close()
+			return err
+		}
+	}
+	if err := tw.Close(); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
+
+func addToBundle(tw *tar.Writer, file string) error {
+	in, err := os.Open(file)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	fi, err := in.Stat()
+	if err != nil {
+		return err
+	}
+	hdr, err := tar.FileInfoHeader(fi, "")
+	if err != nil {
+		return err
+	}
+	hdr.Name = filepath.Base(file)
+	hdr.Mode = 0644
+	hdr.Uid, hdr.Gid, hdr.Uname, hdr.Gname = 0, 0, "", ""
+	if err := tw.WriteHeader(hdr); err != nil {
+		return err
+	}
+	_, err = io.Copy(tw, in)
+	return err
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"relayUpdater/release"
+)
+
+// readRemoteManifest returns the manifest under remoteDir as it is on the
+// server, or nil if there is none.
+func readRemoteManifest(ctx context.Context, t release.Transport, remoteDir, jsonName string) ([]byte, error) {
+	p := release.ShellQuote(path.Join(remoteDir, filepath.Base(jsonName)))
+	out, err := t.Output(ctx, fmt.Sprintf("if [ -f %s ]; then cat %s; fi", p, p))
+	if err != nil {
+		return nil, fmt.Errorf("reading %s on the server: %w", p, err)
+	}
+	return out, nil
+}
+
+// checkNoClobber makes sure the manifest under remoteDir is ours before it
+// is replaced: absent, empty, or listing only versions that entries has
+// too. A server shared between projects could otherwise have another
+// project's manifest overwritten by a wrong -json or -remote-dir.
+func checkNoClobber(ctx context.Context, t release.Transport, remoteDir string, opts *options, entries []release.Entry) error {
+	data, err := readRemoteManifest(ctx, t, remoteDir, opts.jsonName)
+	if err != nil {
+		return &release.UploadError{Err: err}
+	}
+	if len(bytes.TrimSpace(data)) == 0 {
+		return nil
+	}
+	remote := path.Join(remoteDir, filepath.Base(opts.jsonName))
+	theirs, err := release.ParseEntries(data, release.DetectFormat(data))
+	if err != nil {
+		return &release.ManifestError{Err: fmt.Errorf("%s does not look like a manifest (%v); pass -force to overwrite it anyway", remote, err)}
+	}
+	var foreign []string
+	for _, e := range theirs {
+		if release.FindEntry(entries, e.Version) < 0 {
+			foreign = append(foreign, e.Version)
+		}
+	}
+	if len(foreign) > 0 {
+		return &release.ManifestError{Err: fmt.Errorf("%s lists version(s) %s that %s does not; it looks like another project's manifest. Pass -force to overwrite it anyway",
+			remote, strings.Join(foreign, ", "), opts.jsonName)}
+	}
+	return nil
+}
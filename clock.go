@@ -0,0 +1,47 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"relayUpdater/release"
+)
+
+// checkClockSkew compares this machine's clock with the server's and warns
+// if they differ by more than max. Entry dates come from the local clock,
+// so a wrong one puts the release out of order among those made elsewhere.
+// The server answers in whole seconds, measured at the middle of the round
+// trip; skew below a second or so cannot be seen.
+func checkClockSkew(ctx context.Context, remote release.Transport, max time.Duration) {
+	before := time.Now()
+	out, err := remote.Output(ctx, "date -u +%s")
+	after := time.Now()
+	if err != nil {
+		if ctx.Err() == nil {
+			fmt.Fprintln(os.Stderr, "warning: -max-clock-skew: could not read the server's clock:", err)
+		}
+		return
+	}
+	secs, err := strconv.ParseInt(strings.TrimSpace(string(out)), 10, 64)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "warning: -max-clock-skew: unexpected output from date: %q\n", strings.TrimSpace(string(out)))
+		return
+	}
+	// date truncates, so the server's time lies somewhere in the second
+	server := time.Unix(secs, 0).Add(time.Second / 2)
+	local := before.Add(after.Sub(before) / 2)
+	skew := local.Sub(server)
+	if skew.Abs() <= max {
+		return
+	}
+	dir := "ahead of"
+	if skew < 0 {
+		dir = "behind"
+	}
+	fmt.Fprintf(os.Stderr, "warning: this machine's clock is %s %s the server's (more than -max-clock-skew %s); the release date will be off by as much\n",
+		skew.Abs().Round(time.Second), dir, max)
+}
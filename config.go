@@ -0,0 +1,68 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"io"
+	"net/url"
+	"strings"
+)
+
+// configValue is one flag in the -print-config output.
+type configValue struct {
+	Value  any    `json:"value"`
+	Source string `json:"source"`
+}
+
+// printConfig writes every flag's effective value as JSON, with whether it
+// was given on the command line ("flag") or left at its "default". All
+// settings come from flags; there are no config files or environment
+// variables to merge.
+func printConfig(w io.Writer) error {
+	set := map[string]bool{}
+	flag.Visit(func(f *flag.Flag) { set[f.Name] = true })
+
+	cfg := map[string]configValue{}
+	flag.VisitAll(func(f *flag.Flag) {
+		if f.Name == "print-config" {
+			return
+		}
+		src := "default"
+		if set[f.Name] {
+			src = "flag"
+		}
+		cfg[f.Name] = configValue{Value: configFlagValue(f.Value), Source: src}
+	})
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(cfg)
+}
+
+// configFlagValue returns v as a JSON value, with passwords in URLs
+// replaced by "xxxxx".
+func configFlagValue(v flag.Value) any {
+	if g, ok := v.(flag.Getter); ok {
+		switch x := g.Get().(type) {
+		case bool, int:
+			return x
+		case []string:
+			out := make([]string, len(x))
+			for i, s := range x {
+				out[i] = redactURL(s)
+			}
+			return out
+		}
+	}
+	return redactURL(v.String())
+}
+
+func redactURL(s string) string {
+	if !strings.Contains(s, "://") {
+		return s
+	}
+	u, err := url.Parse(s)
+	if err != nil {
+		return s
+	}
+	return u.Redacted()
+}
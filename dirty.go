@@ -0,0 +1,27 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os/exec"
+	"strings"
+
+	"relayUpdater/release"
+)
+
+// checkCleanTree fails if the git working tree at dir has uncommitted
+// changes, listing them as git status --porcelain does.
+func checkCleanTree(ctx context.Context, dir string) error {
+	cmd := exec.CommandContext(ctx, "git", "-C", dir, "status", "--porcelain")
+	out, err := cmd.Output()
+	if err != nil {
+		if ee, ok := err.(*exec.ExitError); ok {
+			err = fmt.Errorf("%w: %s", err, strings.TrimSpace(string(ee.Stderr)))
+		}
+		return &release.BuildError{Err: fmt.Errorf("-require-clean-tree: git status in %s failed: %w", dir, err)}
+	}
+	if dirty := strings.TrimRight(string(out), "\n"); dirty != "" {
+		return &release.BuildError{Err: fmt.Errorf("-require-clean-tree: %s has uncommitted changes (pass -allow-dirty to release anyway):\n%s", dir, dirty)}
+	}
+	return nil
+}
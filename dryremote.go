@@ -0,0 +1,126 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"path"
+	"strings"
+
+	"relayUpdater/release"
+)
+
+// checkRemoteAccess is -dry-run-remote: it logs in and checks that every
+// directory a release writes to is writable, with temporary files that it
+// removes again. Nothing is uploaded and no -latest alias changes. Each
+// check is printed with its result.
+func checkRemoteAccess(ctx context.Context, opts *options, remote release.Transport) error {
+	var passed, failed []string
+	check := func(what string, err error) {
+		if err != nil {
+			fmt.Printf("FAIL %s: %v\n", what, err)
+			failed = append(failed, what)
+			return
+		}
+		fmt.Printf("ok   %s\n", what)
+		passed = append(passed, what)
+	}
+
+	if opts.backend == "ssh" {
+		for _, ho := range opts.hostOptions() {
+			ssh, err := newSSHTransport(ho)
+			if err != nil {
+				return err
+			}
+			if err := ssh.Ping(ctx); err != nil {
+				check("log in over ssh to "+ho.hostPort, err)
+				return &release.UploadError{Err: fmt.Errorf("-dry-run-remote: %w", err)}
+			}
+			check("log in over ssh to "+ho.hostPort, nil)
+		}
+	}
+
+	for _, m := range opts.mirrors {
+		remote, err := mirrorRemote(opts, remote, nil, m)
+		if err != nil {
+			return err
+		}
+		dir := m.dir
+		parent, err := existingDir(ctx, remote, dir)
+		if err != nil {
+			return err
+		}
+		if parent != dir {
+			fmt.Printf("note %s does not exist yet; the first release creates it\n", dir)
+			check("create and remove a file in "+parent+" (to create "+dir+")", probeWrite(ctx, remote, parent))
+			continue
+		}
+		check("create and remove a file in "+dir+" (manifest)", probeWrite(ctx, remote, dir))
+		dl := remoteDownloads(dir)
+		if err := remote.Run(ctx, "test -d "+release.ShellQuote(dl)); err != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			fmt.Printf("note %s does not exist yet; the first release creates it\n", dl)
+			continue
+		}
+		check("create and remove a file in "+dl+" (version directories)", probeWrite(ctx, remote, dl))
+		switch opts.latestMode {
+		case "symlink":
+			check("create and remove a symlink in "+dl+" (-latest aliases)", probeLink(ctx, remote, dl, "-s"))
+		case "hardlink":
+			check("create and remove a hard link in "+dl+" (-latest aliases)", probeLink(ctx, remote, dl, ""))
+		}
+	}
+	if opts.remoteTmpDir != "" {
+		check("create and remove a file in "+opts.remoteTmpDir+" (-remote-tmp-dir)", probeWrite(ctx, remote, opts.remoteTmpDir))
+	}
+	if opts.verifyRemote && opts.verifyMode == "tool" || opts.latestMode == "copy" {
+		tool := opts.remoteTool
+		var err error
+		if tool == "auto" {
+			tool, err = detectChecksumTool(ctx, remote)
+		} else if out, runErr := remote.Output(ctx, tool+" /dev/null"); runErr != nil {
+			err = runErr
+		} else if parseDigest(string(out)) != emptySHA256 {
+			err = fmt.Errorf("unexpected output %q", strings.TrimSpace(string(out)))
+		}
+		check(fmt.Sprintf("hash /dev/null with %q (remote checksums)", tool), err)
+	}
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+
+	if len(failed) > 0 {
+		return &release.UploadError{Err: fmt.Errorf("%d of %d remote check(s) failed:\n  %s", len(failed), len(passed)+len(failed), strings.Join(failed, "\n  "))}
+	}
+	fmt.Printf("✅ %d remote check(s) passed; nothing was changed\n", len(passed))
+	return nil
+}
+
+// existingDir returns dir, or its closest parent that exists on the server
+// if dir does not.
+func existingDir(ctx context.Context, t release.Transport, dir string) (string, error) {
+	for {
+		err := t.Run(ctx, "test -d "+release.ShellQuote(dir))
+		if ctx.Err() != nil {
+			return "", ctx.Err()
+		}
+		if err == nil || dir == "/" || dir == "." {
+			return dir, nil
+		}
+		dir = path.Dir(dir)
+	}
+}
+
+// probeWrite creates a temporary file in dir on the server and removes it.
+func probeWrite(ctx context.Context, t release.Transport, dir string) error {
+	tmpl := release.ShellQuote(dir + "/.relay-check.XXXXXX")
+	return t.Run(ctx, fmt.Sprintf(`f=$(mktemp %s) && rm -f "$f"`, tmpl))
+}
+
+// probeLink makes a link with ln (lnFlag "-s" for a symlink) next to a
+// temporary file in dir and removes both.
+func probeLink(ctx context.Context, t release.Transport, dir, lnFlag string) error {
+	tmpl := release.ShellQuote(dir + "/.relay-check.XXXXXX")
+	return t.Run(ctx, fmt.Sprintf(`f=$(mktemp %s) || exit; ln %s "$f" "$f.ln"; s=$?; rm -f "$f" "$f.ln"; exit $s`, tmpl, lnFlag))
+}
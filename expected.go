@@ -0,0 +1,90 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"relayUpdater/release"
+)
+
+// loadExpectedChecksums reads a file name -> sha256 map, either as a JSON
+// object or in sha256sum's "<sum>  <name>" text format. Sums may be hex or
+// SRI style.
+func loadExpectedChecksums(path string) (map[string]string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	raw := map[string]string{}
+	if trimmed := bytes.TrimSpace(data); len(trimmed) > 0 && trimmed[0] == '{' {
+		if err := json.Unmarshal(trimmed, &raw); err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+	} else {
+		for n, line := range strings.Split(string(data), "\n") {
+			line = strings.TrimSpace(line)
+			if line == "" || strings.HasPrefix(line, "#") {
+				continue
+			}
+			sum, name, ok := strings.Cut(line, " ")
+			if !ok {
+				return nil, fmt.Errorf("%s:%d: want \"<sha256>  <file>\"", path, n+1)
+			}
+			// sha256sum marks binary mode with a leading '*'
+			raw[strings.TrimPrefix(strings.TrimSpace(name), "*")] = sum
+		}
+	}
+	expected := map[string]string{}
+	for name, sum := range raw {
+		hex, err := release.DecodeChecksum(sum)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %s: %w", path, name, err)
+		}
+		expected[filepath.Base(name)] = hex
+	}
+	return expected, nil
+}
+
+// checkExpectedChecksums compares every link with the expected map and
+// reports all differences at once: wrong sums, artifacts the map does not
+// list and listed files that were not built. A file may be listed under its
+// released name or its name before the version was added.
+func checkExpectedChecksums(expected map[string]string, links []release.DownloadInfo, version string) error {
+	var bad []string
+	used := map[string]bool{}
+	for _, l := range links {
+		name := filepath.Base(l.Link)
+		key := name
+		if _, ok := expected[key]; !ok {
+			key = strings.TrimSuffix(name, "-"+version+".zip") + ".zip"
+		}
+		want, ok := expected[key]
+		if !ok {
+			bad = append(bad, fmt.Sprintf("%s: no expected checksum", name))
+			continue
+		}
+		used[key] = true
+		if want != l.Checksum {
+			bad = append(bad, fmt.Sprintf("%s: sha256 %s, expected %s", name, l.Checksum, want))
+		}
+	}
+	var unbuilt []string
+	for name := range expected {
+		if !used[name] {
+			unbuilt = append(unbuilt, name)
+		}
+	}
+	sort.Strings(unbuilt)
+	for _, name := range unbuilt {
+		bad = append(bad, fmt.Sprintf("%s: expected but not built", name))
+	}
+	if len(bad) > 0 {
+		return fmt.Errorf("%d checksum mismatch(es) against the expected checksums:\n  %s", len(bad), strings.Join(bad, "\n  "))
+	}
+	return nil
+}
@@ -0,0 +1,157 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+
+	"relayUpdater/release"
+)
+
+// httpClient is the client every HTTP request goes through, set up with
+// -ca-file and -insecure-skip-verify.
+func httpClient(opts *options) (*http.Client, error) {
+	if opts.insecureTLS {
+		fmt.Fprintln(os.Stderr, "warning: -insecure-skip-verify: TLS certificates are not checked")
+	}
+	client, err := release.NewHTTPClient(opts.caFile, opts.insecureTLS)
+	if err != nil {
+		return nil, fmt.Errorf("invalid -ca-file: %w", err)
+	}
+	return client, nil
+}
+
+// fetchRelease is the client side: it reads the manifest at opts.fetchURL
+// and downloads one release's artifacts into opts.fetchDir, checking each
+// against its recorded sha256. Links resolve relative to the manifest URL;
+// mirrors are tried in order if the primary fails.
+func fetchRelease(ctx context.Context, opts *options) error {
+	client, err := httpClient(opts)
+	if err != nil {
+		return err
+	}
+	all, err := release.FetchEntries(ctx, client, opts.fetchURL)
+	if err != nil {
+		return &release.ManifestError{Err: fmt.Errorf("failed to read manifest: %w", err)}
+	}
+	entries := release.ForChannel(all, opts.channel)
+
+	want := opts.manualVer
+	if want == "" {
+		if !hasValidVersion(entries) {
+			return &release.ManifestError{Err: fmt.Errorf("%s lists no valid version", opts.fetchURL)}
+		}
+		want = release.HighestVersion(entries).String()
+	}
+	i := release.FindEntry(entries, want)
+	if i < 0 && release.FindEntry(all, want) >= 0 {
+		return &release.ManifestError{Err: fmt.Errorf("version %s has no artifacts in the %s channel", want, channelName(opts.channel))}
+	}
+	if i < 0 {
+		return &release.ManifestError{Err: fmt.Errorf("version %s is not in %s", want, opts.fetchURL)}
+	}
+	e := entries[i]
+
+	base, err := url.Parse(opts.fetchURL)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(opts.fetchDir, 0755); err != nil {
+		return fmt.Errorf("failed to create fetch dir: %w", err)
+	}
+	targets := splitList(opts.targets)
+	n := 0
+	for _, l := range e.Links {
+		name := path.Base(l.Link)
+		if len(targets) > 0 && matchTarget(name, targets) == "" {
+			continue
+		}
+		ref, err := url.Parse(l.Link)
+		if err != nil {
+			return &release.ManifestError{Err: fmt.Errorf("bad link %q: %w", l.Link, err)}
+		}
+		urls := append([]string{base.ResolveReference(ref).String()}, l.Mirrors...)
+		dest := filepath.Join(opts.fetchDir, name)
+		if err := downloadAny(ctx, client, urls, dest, l.Checksum, opts.resume); err != nil {
+			return err
+		}
+		fmt.Println("fetched", dest)
+		n++
+	}
+	if n == 0 {
+		return fmt.Errorf("version %s has no matching artifacts", e.Version)
+	}
+	fmt.Printf("✅ Fetched %d file(s) of version %s\n", n, e.Version)
+	return nil
+}
+
+// downloadAny tries each URL in turn until one downloads and verifies.
+func downloadAny(ctx context.Context, client *http.Client, urls []string, dest, sum string, resume bool) error {
+	var errs []error
+	for _, u := range urls {
+		err := release.Download(ctx, client, u, dest, sum, resume)
+		if err == nil {
+			return nil
+		}
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		errs = append(errs, err)
+	}
+	return fmt.Errorf("download of %s failed: %w", filepath.Base(dest), errors.Join(errs...))
+}
+
+// channelName names a -channel value in messages.
+func channelName(ch string) string {
+	if ch == "" {
+		return "stable"
+	}
+	return ch
+}
+
+// errUpdateAvailable is returned by checkUpdate when there is a newer
+// release; it is not a failure, only a distinct exit status.
+var errUpdateAvailable = errors.New("update available")
+
+// checkUpdate reads the manifest at opts.checkURL and reports whether a
+// release newer than opts.current is rolled out to this client, printing
+// its download links if so.
+func checkUpdate(ctx context.Context, opts *options) error {
+	client, err := httpClient(opts)
+	if err != nil {
+		return err
+	}
+	entries, err := release.FetchEntries(ctx, client, opts.checkURL)
+	if err != nil {
+		return &release.ManifestError{Err: fmt.Errorf("failed to read manifest: %w", err)}
+	}
+	// a beta-only release is no update for a stable client
+	entries = release.ForChannel(entries, opts.channel)
+	e, ok, err := release.NewerRelease(entries, opts.current, opts.clientID)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		fmt.Printf("up to date (%s)\n", opts.current)
+		return nil
+	}
+
+	base, err := url.Parse(opts.checkURL)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("update available: %s -> %s\n", opts.current, e.Version)
+	for _, l := range e.Links {
+		ref, err := url.Parse(l.Link)
+		if err != nil {
+			return &release.ManifestError{Err: fmt.Errorf("bad link %q: %w", l.Link, err)}
+		}
+		fmt.Printf("%s  %s\n", l.Checksum, base.ResolveReference(ref))
+	}
+	return errUpdateAvailable
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	semver "github.com/Masterminds/semver/v3"
+
+	"relayUpdater/release"
+)
+
+// collectGarbage finds files under remoteDir/downloads that no manifest
+// entry references and no symlink points at, and removes them if del is set.
+// The manifest itself is never touched.
+func collectGarbage(ctx context.Context, t release.Transport, remoteDir, jsonName string, entries []release.Entry, del bool) error {
+	remoteDir = filepath.Clean(remoteDir)
+	base := remoteDownloads(remoteDir)
+
+	keep := map[string]bool{filepath.Join(remoteDir, filepath.Base(jsonName)): true}
+	for _, e := range entries {
+		for _, l := range append(append([]release.DownloadInfo{}, e.Links...), e.Latest...) {
+			keep[filepath.Join(remoteDir, l.Link)] = true
+		}
+		sums := filepath.Join(base, e.Version, sumsFileName)
+		keep[sums] = true
+		keep[sums+".sig"] = true
+		keep[filepath.Join(base, e.Version, notesFileName)] = true
+	}
+
+	// readlink rather than GNU find's -printf %l, which BSD find lacks
+	out, err := t.Output(ctx, "find "+release.ShellQuote(base)+` -type l -exec sh -c 'for l; do printf "%s\t%s\n" "$l" "$(readlink "$l")"; done' sh {} +`)
+	if err != nil {
+		return fmt.Errorf("listing remote symlinks: %w", err)
+	}
+	for _, line := range strings.Split(strings.TrimSpace(string(out)), "\n") {
+		link, target, ok := strings.Cut(line, "\t")
+		if !ok {
+			continue
+		}
+		if !filepath.IsAbs(target) {
+			target = filepath.Join(filepath.Dir(link), target)
+		}
+		keep[filepath.Clean(target)] = true
+	}
+
+	out, err = t.Output(ctx, "find "+release.ShellQuote(base)+" -type f")
+	if err != nil {
+		return fmt.Errorf("listing remote files: %w", err)
+	}
+	var orphans []string
+	for _, f := range strings.Split(strings.TrimSpace(string(out)), "\n") {
+		// -latest-mode copy leaves regular files where the links would be
+		if filepath.Dir(f) == base && strings.HasSuffix(f, "-latest.zip") {
+			continue
+		}
+		if f != "" && !keep[filepath.Clean(f)] {
+			orphans = append(orphans, f)
+		}
+	}
+	sort.Strings(orphans)
+
+	if old := trimmedVersions(base, orphans, entries); len(old) > 0 {
+		msg := fmt.Sprintf("%s has files of %s, older than every version in %s; its history was probably trimmed with -max-history", base, strings.Join(old, ", "), jsonName)
+		if del {
+			return fmt.Errorf("%s, so nothing is deleted; run -gc with -max-history and -full-history-file to read every version", msg)
+		}
+		fmt.Println("warning:", msg)
+	}
+
+	if len(orphans) == 0 {
+		fmt.Println("no orphaned files under", base)
+		return nil
+	}
+	for _, f := range orphans {
+		fmt.Println("orphan:", f)
+	}
+	if !del {
+		fmt.Printf("%d orphaned file(s); rerun with -gc-delete to remove them\n", len(orphans))
+		return nil
+	}
+
+	quoted := make([]string, len(orphans))
+	for i, f := range orphans {
+		quoted[i] = release.ShellQuote(f)
+	}
+	if err := t.Run(ctx, "rm -f "+strings.Join(quoted, " ")); err != nil {
+		return fmt.Errorf("removing orphans: %w", err)
+	}
+	fmt.Printf("removed %d orphaned file(s)\n", len(orphans))
+	return nil
+}
+
+// trimmedVersions returns the versions below the lowest one in entries
+// that orphans lie under in base/<version>/. A manifest written with
+// -max-history and no -full-history-file leaves exactly those behind, and
+// their files are still served.
+func trimmedVersions(base string, orphans []string, entries []release.Entry) []string {
+	var lowest *semver.Version
+	for _, e := range entries {
+		v, err := semver.NewVersion(strings.TrimSpace(e.Version))
+		if err == nil && (lowest == nil || v.LessThan(lowest)) {
+			lowest = v
+		}
+	}
+	if lowest == nil {
+		return nil
+	}
+	seen := map[string]bool{}
+	var old []string
+	for _, f := range orphans {
+		rel, err := filepath.Rel(base, f)
+		if err != nil {
+			continue
+		}
+		dir, _, ok := strings.Cut(filepath.ToSlash(rel), "/")
+		if !ok || seen[dir] {
+			continue
+		}
+		if v, err := semver.NewVersion(dir); err == nil && v.LessThan(lowest) {
+			seen[dir] = true
+			old = append(old, dir)
+		}
+	}
+	return old
+}
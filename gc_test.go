@@ -0,0 +1,166 @@
+package main
+
+import (
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"relayUpdater/release"
+)
+
+// gcTree creates remoteDir/downloads/<version>/client-<version>.zip for
+// each version and returns the entries that list them.
+func gcTree(t *testing.T, remoteDir string, versions ...string) []release.Entry {
+	t.Helper()
+	var entries []release.Entry
+	for _, v := range versions {
+		dir := filepath.Join(remoteDir, dlDir, v)
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			t.Fatal(err)
+		}
+		name := "client-" + v + ".zip"
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(v), 0644); err != nil {
+			t.Fatal(err)
+		}
+		entries = append(entries, release.Entry{Version: v, Links: []release.DownloadInfo{{Link: filepath.Join(dlDir, v, name)}}})
+	}
+	return entries
+}
+
+func TestCollectGarbageTrimmedHistory(t *testing.T) {
+	remoteDir := t.TempDir()
+	entries := gcTree(t, remoteDir, "1.0.0", "1.1.0", "1.2.0")
+	tr := &release.LocalTransport{Stdout: io.Discard, Stderr: io.Discard}
+
+	// -max-history 1 without -full-history-file left only the newest
+	err := collectGarbage(context.Background(), tr, remoteDir, "relayClient.json", entries[2:], true)
+	if err == nil {
+		t.Fatal("gc deleted with a trimmed manifest; want an error")
+	}
+	for _, v := range []string{"1.0.0", "1.1.0"} {
+		if _, err := os.Stat(filepath.Join(remoteDir, dlDir, v, "client-"+v+".zip")); err != nil {
+			t.Errorf("file of %s is gone: %v", v, err)
+		}
+	}
+}
+
+func TestCollectGarbageFullHistory(t *testing.T) {
+	remoteDir := t.TempDir()
+	entries := gcTree(t, remoteDir, "1.0.0", "1.1.0")
+	// a failed release left a file no entry lists
+	stray := filepath.Join(remoteDir, dlDir, "1.1.0", "stray.zip")
+	if err := os.WriteFile(stray, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	tr := &release.LocalTransport{Stdout: io.Discard, Stderr: io.Discard}
+
+	if err := collectGarbage(context.Background(), tr, remoteDir, "relayClient.json", entries, true); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(stray); !os.IsNotExist(err) {
+		t.Errorf("orphan %s was not removed: %v", stray, err)
+	}
+	for _, e := range entries {
+		if _, err := os.Stat(filepath.Join(remoteDir, e.Links[0].Link)); err != nil {
+			t.Errorf("listed file removed: %v", err)
+		}
+	}
+}
+
+// TestCollectGarbageKeepsLinkTargets links to two files no entry lists,
+// once with a relative and once with an absolute symlink: -gc must keep
+// both, as -dedupe-storage and -latest aliases rely on it.
+func TestCollectGarbageKeepsLinkTargets(t *testing.T) {
+	remoteDir := t.TempDir()
+	entries := gcTree(t, remoteDir, "1.0.0", "1.1.0")
+	old := filepath.Join(remoteDir, dlDir, "1.0.0")
+	for _, name := range []string{"relative.zip", "absolute.zip"} {
+		if err := os.WriteFile(filepath.Join(old, name), nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	links := filepath.Join(remoteDir, dlDir, "1.1.0")
+	if err := os.Symlink("../1.0.0/relative.zip", filepath.Join(links, "relative.zip")); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink(filepath.Join(old, "absolute.zip"), filepath.Join(links, "absolute.zip")); err != nil {
+		t.Fatal(err)
+	}
+	tr := &release.LocalTransport{Stdout: io.Discard, Stderr: io.Discard}
+
+	if err := collectGarbage(context.Background(), tr, remoteDir, "relayClient.json", entries, true); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"relative.zip", "absolute.zip"} {
+		if _, err := os.Stat(filepath.Join(old, name)); err != nil {
+			t.Errorf("link target %s removed: %v", name, err)
+		}
+	}
+}
+
+func TestTrimmedVersions(t *testing.T) {
+	base := "/srv/downloads"
+	entries := []release.Entry{{Version: "2.0.0"}, {Version: "v2.1.0"}, {Version: " "}}
+	orphans := []string{
+		"/srv/downloads/1.0.0/a.zip",
+		"/srv/downloads/1.0.0/b.zip",
+		"/srv/downloads/1.9.9/c.zip",
+		"/srv/downloads/2.2.0/failed.zip", // newer: a failed release
+		"/srv/downloads/notes/readme.txt",
+	}
+	got := trimmedVersions(base, orphans, entries)
+	want := []string{"1.0.0", "1.9.9"}
+	if len(got) != len(want) || got[0] != want[0] || got[1] != want[1] {
+		t.Fatalf("trimmedVersions = %v, want %v", got, want)
+	}
+	if got := trimmedVersions(base, orphans, nil); got != nil {
+		t.Errorf("with no entries = %v, want nil", got)
+	}
+}
+
+// fakeRemote returns an SSHTransport whose ssh runs the remote command
+// locally with sh, so a temp dir stands in for the server.
+func fakeRemote(t *testing.T) release.Transport {
+	t.Helper()
+	ssh := filepath.Join(t.TempDir(), "ssh")
+	if err := os.WriteFile(ssh, []byte("#!/bin/sh\nfor a; do last=$a; done\nexec sh -c \"$last\"\n"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	return &release.SSHTransport{Host: "example.com", User: "deploy", SSHCommand: ssh}
+}
+
+func TestCollectGarbageQuotesNames(t *testing.T) {
+	remoteDir := t.TempDir()
+	dir := filepath.Join(remoteDir, dlDir, "1.0.0")
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	kept := filepath.Join(dir, "client-1.0.0.zip")
+	if err := os.WriteFile(kept, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	entries := []release.Entry{{Version: "1.0.0", Links: []release.DownloadInfo{{Link: filepath.Join(dlDir, "1.0.0", "client-1.0.0.zip")}}}}
+	// a name the shell would expand inside double quotes; the commands
+	// run in the working directory, so that is where a canary would land
+	t.Chdir(remoteDir)
+	canary := filepath.Join(remoteDir, "ran")
+	stray := filepath.Join(dir, "$(touch ran)`touch ran`$HOME'.zip")
+	if err := os.WriteFile(stray, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := collectGarbage(context.Background(), fakeRemote(t), remoteDir, "relayClient.json", entries, true); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(canary); !os.IsNotExist(err) {
+		t.Errorf("the orphan's name was run as a command")
+	}
+	if _, err := os.Stat(stray); !os.IsNotExist(err) {
+		t.Errorf("orphan %s was not removed: %v", stray, err)
+	}
+	if _, err := os.Stat(kept); err != nil {
+		t.Errorf("listed file removed: %v", err)
+	}
+}
@@ -0,0 +1,165 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	semver "github.com/Masterminds/semver/v3"
+
+	"relayUpdater/release"
+)
+
+// listRemote prints the versions and files under remoteDir/downloads and
+// compares them with the manifest: versions only the server has, entries
+// the server lacks, and files missing from or extra in a version
+// directory. Discrepancies make it return an error. A non-zero since limits
+// the listing to entries released at or after it; versions only the server
+// has carry no date and are then left out.
+func listRemote(ctx context.Context, t release.Transport, remoteDir, jsonName string, entries []release.Entry, since time.Time) error {
+	base := remoteDownloads(remoteDir)
+	// find from inside base prints ./-relative paths without GNU's -printf
+	out, err := t.Output(ctx, "cd "+release.ShellQuote(base)+" && find . -mindepth 1 -maxdepth 2 ! -type d")
+	if err != nil {
+		return fmt.Errorf("listing %s: %w", base, err)
+	}
+	remote := map[string][]string{}
+	var aliases []string
+	for _, p := range strings.Split(strings.TrimSpace(string(out)), "\n") {
+		p = strings.TrimPrefix(p, "./")
+		if p == "" {
+			continue
+		}
+		if dir, file, ok := strings.Cut(p, "/"); ok {
+			remote[dir] = append(remote[dir], file)
+		} else {
+			aliases = append(aliases, p)
+		}
+	}
+
+	expected := map[string][]string{}
+	var older []string
+	for _, e := range entries {
+		if !since.IsZero() && e.Date < since.UnixNano() {
+			older = append(older, strings.TrimSpace(e.Version))
+			continue
+		}
+		v := strings.TrimSpace(e.Version)
+		var names []string
+		for _, l := range e.Links {
+			names = append(names, path.Base(l.Link))
+		}
+		expected[v] = names
+	}
+
+	versions := make([]string, 0, len(remote))
+	for v := range remote {
+		if _, ok := expected[v]; ok || since.IsZero() {
+			versions = append(versions, v)
+		}
+	}
+	for v := range expected {
+		if _, ok := remote[v]; !ok {
+			versions = append(versions, v)
+		}
+	}
+	sortVersions(versions)
+
+	var problems []string
+	if !since.IsZero() {
+		fmt.Printf("%s (since %s, %d older version(s) not shown):\n", base, since.Format(time.RFC3339), len(older))
+	} else {
+		fmt.Printf("%s:\n", base)
+	}
+	for _, v := range versions {
+		files, onServer := remote[v]
+		want, inManifest := expected[v]
+		switch {
+		case !onServer:
+			fmt.Printf("  %s  (not on the server)\n", v)
+			problems = append(problems, fmt.Sprintf("%s is in %s but not on the server", v, jsonName))
+			continue
+		case !inManifest:
+			fmt.Printf("  %s  (not in %s)\n", v, jsonName)
+			problems = append(problems, fmt.Sprintf("%s is on the server but not in %s", v, jsonName))
+		default:
+			fmt.Printf("  %s\n", v)
+		}
+		sort.Strings(files)
+		for _, f := range files {
+			fmt.Printf("    %s\n", f)
+		}
+		if !inManifest {
+			continue
+		}
+		have := map[string]bool{}
+		for _, f := range files {
+			have[f] = true
+		}
+		listed := map[string]bool{sumsFileName: true, sumsFileName + ".sig": true, notesFileName: true}
+		for _, f := range want {
+			listed[f] = true
+			if !have[f] {
+				problems = append(problems, fmt.Sprintf("%s/%s is in %s but not on the server", v, f, jsonName))
+			}
+		}
+		for _, f := range files {
+			if !listed[f] {
+				problems = append(problems, fmt.Sprintf("%s/%s is on the server but not in %s", v, f, jsonName))
+			}
+		}
+	}
+	sort.Strings(aliases)
+	for _, a := range aliases {
+		fmt.Printf("  %s\n", a)
+	}
+
+	if len(problems) > 0 {
+		return fmt.Errorf("%d discrepancy(ies) between the server and %s:\n  %s", len(problems), jsonName, strings.Join(problems, "\n  "))
+	}
+	fmt.Printf("%s matches %s\n", base, jsonName)
+	return nil
+}
+
+// parseSince reads -since: an RFC 3339 time, a date (2006-01-02, UTC) or a
+// duration back from now such as 30d, 2w or 12h.
+func parseSince(s string, now time.Time) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, s); err == nil {
+		return t, nil
+	}
+	if t, err := time.Parse(time.DateOnly, s); err == nil {
+		return t, nil
+	}
+	if n, unit := strings.TrimRight(s, "dw"), strings.TrimLeft(s, "0123456789"); unit == "d" || unit == "w" {
+		days, err := strconv.Atoi(n)
+		if err == nil && days >= 0 {
+			if unit == "w" {
+				days *= 7
+			}
+			return now.AddDate(0, 0, -days), nil
+		}
+	}
+	if d, err := time.ParseDuration(s); err == nil && d >= 0 {
+		return now.Add(-d), nil
+	}
+	return time.Time{}, fmt.Errorf("invalid -since %q (want an RFC 3339 time, a date like 2006-01-02 or an age like 30d, 2w or 12h)", s)
+}
+
+// sortVersions sorts by semver, with names that are not versions last.
+func sortVersions(vs []string) {
+	sort.SliceStable(vs, func(i, j int) bool {
+		a, errA := semver.NewVersion(vs[i])
+		b, errB := semver.NewVersion(vs[j])
+		switch {
+		case errA == nil && errB == nil:
+			return a.LessThan(b)
+		case errA == nil || errB == nil:
+			return errA == nil
+		}
+		return vs[i] < vs[j]
+	})
+}
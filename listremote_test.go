@@ -0,0 +1,36 @@
+package main
+
+import (
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"relayUpdater/release"
+)
+
+// TestListRemote lists a server that matches the manifest and then one
+// with a stray file in a version directory.
+func TestListRemote(t *testing.T) {
+	remoteDir := t.TempDir()
+	entries := gcTree(t, remoteDir, "1.0.0", "1.1.0")
+	alias := filepath.Join(remoteDir, dlDir, "client-latest.zip")
+	if err := os.Symlink(filepath.Join(remoteDir, entries[1].Links[0].Link), alias); err != nil {
+		t.Fatal(err)
+	}
+	tr := &release.LocalTransport{Stdout: io.Discard, Stderr: io.Discard}
+
+	if err := listRemote(context.Background(), tr, remoteDir, "relayClient.json", entries, time.Time{}); err != nil {
+		t.Fatalf("matching server: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(remoteDir, dlDir, "1.0.0", "stray.zip"), nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	err := listRemote(context.Background(), tr, remoteDir, "relayClient.json", entries, time.Time{})
+	if err == nil || !strings.Contains(err.Error(), "1.0.0/stray.zip is on the server") {
+		t.Errorf("stray file: got %v", err)
+	}
+}
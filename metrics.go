@@ -0,0 +1,65 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// releaseMetrics collects figures about a release for the Prometheus
+// textfile collector.
+type releaseMetrics struct {
+	mu            sync.Mutex // guards bytesUploaded for concurrent mirrors
+	start         time.Time
+	checksumTime  time.Duration
+	bytesUploaded int64
+	artifacts     int
+}
+
+// addUploaded counts the size of each local file towards bytesUploaded.
+func (m *releaseMetrics) addUploaded(paths ...string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, p := range paths {
+		if fi, err := os.Stat(p); err == nil {
+			m.bytesUploaded += fi.Size()
+		}
+	}
+}
+
+// write renders the metrics in Prometheus text format. The file is
+// replaced atomically so the collector never reads a partial file.
+func (m *releaseMetrics) write(path, version string) error {
+	label := fmt.Sprintf(`{version=%q}`, version)
+	var b strings.Builder
+	gauge := func(name, help string, value any) {
+		fmt.Fprintf(&b, "# HELP %s %s\n# TYPE %s gauge\n%s%s %v\n", name, help, name, name, label, value)
+	}
+	gauge("relay_updater_release_duration_seconds", "Wall time of the last release.", time.Since(m.start).Seconds())
+	gauge("relay_updater_checksum_duration_seconds", "Time spent hashing artifacts in the last release.", m.checksumTime.Seconds())
+	gauge("relay_updater_uploaded_bytes", "Bytes uploaded by the last release.", m.bytesUploaded)
+	gauge("relay_updater_artifacts", "Number of artifacts in the last release.", m.artifacts)
+	gauge("relay_updater_last_release_timestamp_seconds", "Unix time the last release finished.", time.Now().Unix())
+
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".metrics-*")
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.WriteString(b.String()); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := os.Chmod(tmp.Name(), 0644); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
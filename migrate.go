@@ -0,0 +1,78 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"relayUpdater/release"
+)
+
+// migrateManifest reads the manifest in whatever supported shape it has,
+// brings every entry up to the current schema and rewrites it in
+// -manifest-format, printing each change. Only the local file is touched.
+func migrateManifest(opts *options) error {
+	data, err := os.ReadFile(opts.jsonName)
+	if err != nil {
+		return &release.ManifestError{Err: fmt.Errorf("failed to read JSON: %w", err)}
+	}
+	from := release.DetectFormat(data)
+	entries, err := release.ParseEntries(data, from)
+	if err != nil {
+		return &release.ManifestError{Err: fmt.Errorf("failed to parse %s as %s: %w", opts.jsonName, from, err)}
+	}
+
+	var changes []string
+	if from != opts.format {
+		changes = append(changes, fmt.Sprintf("format %s -> %s", from, opts.format))
+	}
+	if bytes.Contains(data, []byte(`"hash"`)) && !slices.Contains(opts.manifest.ChecksumKeys, "hash") {
+		changes = append(changes, `legacy "hash" checksums now written as "sha256"`)
+	}
+	if len(entries) > 0 && !bytes.Contains(data, []byte(`"date-rfc3339"`)) {
+		changes = append(changes, `"date-rfc3339" added next to every date`)
+	}
+	for i := range entries {
+		entries[i].HumanDate = true
+	}
+	for i := range entries {
+		e := &entries[i]
+		if v := strings.TrimSpace(e.Version); v != e.Version {
+			changes = append(changes, fmt.Sprintf("%q: version trimmed", e.Version))
+			e.Version = v
+		}
+		if e.Date == 0 {
+			// the best guess left is when the files were built
+			if fi, err := os.Stat(filepath.Join(dlDir, e.Version)); err == nil {
+				e.Date = fi.ModTime().UTC().UnixNano()
+				changes = append(changes, fmt.Sprintf("%s: date set from %s", e.Version, fi.ModTime().UTC().Format("2006-01-02 15:04:05")))
+			}
+		}
+		sized := 0
+		for j := range e.Links {
+			l := &e.Links[j]
+			if l.Size != 0 {
+				continue
+			}
+			if fi, err := os.Stat(l.Link); err == nil {
+				l.Size = fi.Size()
+				sized++
+			}
+		}
+		if sized > 0 {
+			changes = append(changes, fmt.Sprintf("%s: size added to %d link(s)", e.Version, sized))
+		}
+	}
+
+	if _, err := writeManifest(opts, entries, false); err != nil {
+		return err
+	}
+	for _, c := range changes {
+		fmt.Println("migrated:", c)
+	}
+	fmt.Printf("✅ Migrated %s (%d entries, %d change(s)); the next release uploads it\n", opts.jsonName, len(entries), len(changes))
+	return nil
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"strings"
+
+	"relayUpdater/release"
+)
+
+// mirror is one -remote-dir: a directory on the -host server, or one on a
+// host of its own, given as [user@]host[:port]:/dir.
+type mirror struct {
+	host string // [user@]host[:port]; "" is -host
+	dir  string
+}
+
+// parseMirror splits a -remote-dir value. Anything before the first ":/"
+// is a host unless the value starts with "/", so a mirror on another host
+// needs an absolute directory.
+func parseMirror(spec string) (mirror, error) {
+	if spec == "" {
+		return mirror{}, fmt.Errorf("empty directory")
+	}
+	i := strings.Index(spec, ":/")
+	if strings.HasPrefix(spec, "/") || i < 0 {
+		return mirror{dir: spec}, nil
+	}
+	m := mirror{host: spec[:i], dir: spec[i+1:]}
+	if _, _, _, err := release.ParseLogin(m.host); err != nil {
+		return mirror{}, fmt.Errorf("host of %s: %w", spec, err)
+	}
+	return m, nil
+}
+
+// String returns the mirror as it was given, for messages and the resume
+// state.
+func (m mirror) String() string {
+	if m.host == "" {
+		return m.dir
+	}
+	return m.host + ":" + m.dir
+}
+
+// forMirror returns the options to reach m with: o itself, or a copy whose
+// -host is m's host. The -user applies unless m names its own.
+func (o *options) forMirror(m mirror) *options {
+	if m.host == "" {
+		return o
+	}
+	mo := *o
+	mo.hostPort = m.host
+	return &mo
+}
+
+// hostOptions returns the options for each distinct host the mirrors are
+// on, -host first if any mirror uses it.
+func (o *options) hostOptions() []*options {
+	var out []*options
+	seen := map[string]bool{}
+	for _, m := range o.mirrors {
+		if !seen[m.host] {
+			seen[m.host] = true
+			out = append(out, o.forMirror(m))
+		}
+	}
+	return out
+}
+
+// mirrorRemote returns the transport for m: remote itself for a directory
+// on -host, or one to m's host built like remote. A recorded dry run keeps
+// writing to the same script.
+func mirrorRemote(opts *options, remote release.Transport, out io.Writer, m mirror) (release.Transport, error) {
+	if m.host == "" {
+		return remote, nil
+	}
+	mo := opts.forMirror(m)
+	if st, ok := remote.(*release.ScriptTransport); ok {
+		return newScriptTransport(mo, st.W)
+	}
+	return newTransport(mo, out)
+}
@@ -0,0 +1,59 @@
+package main
+
+import "testing"
+
+func TestParseMirror(t *testing.T) {
+	for _, c := range []struct {
+		spec string
+		want mirror
+		err  bool
+	}{
+		{spec: "/srv/www", want: mirror{dir: "/srv/www"}},
+		{spec: "public_html", want: mirror{dir: "public_html"}},
+		// a colon without a slash after it is part of a relative directory
+		{spec: "www:old", want: mirror{dir: "www:old"}},
+		{spec: "/srv/a:/b", want: mirror{dir: "/srv/a:/b"}},
+		{spec: "mirror.example.org:/srv/www", want: mirror{host: "mirror.example.org", dir: "/srv/www"}},
+		{spec: "deploy@mirror.example.org:2222:/srv/www", want: mirror{host: "deploy@mirror.example.org:2222", dir: "/srv/www"}},
+		{spec: "[::1]:22:/srv/www", want: mirror{host: "[::1]:22", dir: "/srv/www"}},
+		{spec: "", err: true},
+		{spec: "@mirror.example.org:/srv/www", err: true},
+		{spec: "mirror.example.org:99999:/srv/www", err: true},
+	} {
+		got, err := parseMirror(c.spec)
+		if c.err {
+			if err == nil {
+				t.Errorf("parseMirror(%q) = %+v, want an error", c.spec, got)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("parseMirror(%q): %v", c.spec, err)
+			continue
+		}
+		if got != c.want {
+			t.Errorf("parseMirror(%q) = %+v, want %+v", c.spec, got, c.want)
+		}
+		if got.String() != c.spec {
+			t.Errorf("parseMirror(%q).String() = %q", c.spec, got.String())
+		}
+	}
+}
+
+func TestHostOptions(t *testing.T) {
+	o := &options{hostPort: "example.org"}
+	for _, s := range []string{"/srv/a", "mirror.example.org:/srv/b", "/srv/c", "mirror.example.org:/srv/d"} {
+		m, err := parseMirror(s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		o.mirrors = append(o.mirrors, m)
+	}
+	hosts := o.hostOptions()
+	if len(hosts) != 2 || hosts[0] != o || hosts[1].hostPort != "mirror.example.org" {
+		t.Fatalf("hostOptions = %v, want -host then mirror.example.org", hosts)
+	}
+	if o.hostPort != "example.org" {
+		t.Errorf("forMirror changed -host to %s", o.hostPort)
+	}
+}
@@ -0,0 +1,152 @@
+package release
+
+import (
+	"archive/zip"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// ChecksumCacheFile is the default name of the checksum cache.
+const ChecksumCacheFile = ".checksum-cache.json"
+
+// ComputeChecksum returns the hex sha256 of the file at path.
+func ComputeChecksum(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// ContentChecksum hashes what a zip archive holds rather than its bytes:
+// the hex sha256 of one "<name>\x00<sha256 of its data>\n" line per file,
+// sorted by name. Two archives with the same files get the same value even
+// if they were compressed differently.
+func ContentChecksum(path string) (string, error) {
+	r, err := zip.OpenReader(path)
+	if err != nil {
+		return "", err
+	}
+	defer r.Close()
+	var lines []string
+	for _, f := range r.File {
+		if strings.HasSuffix(f.Name, "/") {
+			continue
+		}
+		rc, err := f.Open()
+		if err != nil {
+			return "", fmt.Errorf("%s: %w", f.Name, err)
+		}
+		h := sha256.New()
+		_, err = io.Copy(h, rc)
+		rc.Close()
+		if err != nil {
+			return "", fmt.Errorf("%s: %w", f.Name, err)
+		}
+		lines = append(lines, f.Name+"\x00"+hex.EncodeToString(h.Sum(nil))+"\n")
+	}
+	sort.Strings(lines)
+	h := sha256.New()
+	for _, l := range lines {
+		io.WriteString(h, l)
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// DirChecksum is one integrity value for a whole version directory: the
+// hex sha256 of the artifacts' hex checksums, concatenated in order of
+// file name. A client can recompute it from the files it downloaded.
+func DirChecksum(links []DownloadInfo) string {
+	sorted := append([]DownloadInfo(nil), links...)
+	sort.Slice(sorted, func(i, j int) bool {
+		return path.Base(filepath.ToSlash(sorted[i].Link)) < path.Base(filepath.ToSlash(sorted[j].Link))
+	})
+	h := sha256.New()
+	for _, l := range sorted {
+		io.WriteString(h, l.Checksum)
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// cacheEntry remembers the checksum of a file as it was when hashed.
+type cacheEntry struct {
+	Size    int64  `json:"size"`
+	ModTime int64  `json:"mtime-unixnano"`
+	SHA256  string `json:"sha256"`
+}
+
+// ChecksumCache maps absolute file paths to their last computed checksum.
+// A nil *ChecksumCache is valid and always recomputes.
+type ChecksumCache struct {
+	path    string
+	entries map[string]cacheEntry
+	dirty   bool
+}
+
+// LoadChecksumCache reads the cache at path; a missing file is an empty cache.
+func LoadChecksumCache(path string) (*ChecksumCache, error) {
+	c := &ChecksumCache{path: path, entries: map[string]cacheEntry{}}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return c, nil
+		}
+		return nil, err
+	}
+	if err := json.Unmarshal(data, &c.entries); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// Checksum returns the sha256 of path, reusing the cached value when the
+// file's size and modification time are unchanged.
+func (c *ChecksumCache) Checksum(path string) (string, error) {
+	if c == nil {
+		return ComputeChecksum(path)
+	}
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return "", err
+	}
+	fi, err := os.Stat(abs)
+	if err != nil {
+		return "", err
+	}
+	if ce, ok := c.entries[abs]; ok && ce.Size == fi.Size() && ce.ModTime == fi.ModTime().UnixNano() {
+		return ce.SHA256, nil
+	}
+	sum, err := ComputeChecksum(abs)
+	if err != nil {
+		return "", err
+	}
+	c.entries[abs] = cacheEntry{Size: fi.Size(), ModTime: fi.ModTime().UnixNano(), SHA256: sum}
+	c.dirty = true
+	return sum, nil
+}
+
+// Save writes the cache back if anything changed.
+func (c *ChecksumCache) Save() error {
+	if c == nil || !c.dirty {
+		return nil
+	}
+	out, err := json.MarshalIndent(c.entries, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(c.path, out, 0644)
+}
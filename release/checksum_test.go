@@ -0,0 +1,82 @@
+package release
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// TestChecksumCache hashes a file through a saved cache whose entry has been
+// replaced by a marker: the marker comes back only while the file's size
+// and modification time still match.
+func TestChecksumCache(t *testing.T) {
+	mtime := time.Unix(1700000000, 0)
+	for _, tc := range []struct {
+		name    string
+		data    string
+		mtime   time.Time
+		wantHit bool
+	}{
+		{"unchanged", "client", mtime, true},
+		{"size", "client-1.2.3", mtime, false},
+		{"mtime", "client", mtime.Add(time.Second), false},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			dir := t.TempDir()
+			file := filepath.Join(dir, "client.zip")
+			writeFileAt(t, file, "client", mtime)
+			cachePath := filepath.Join(dir, ChecksumCacheFile)
+			c, err := LoadChecksumCache(cachePath)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, err := c.Checksum(file); err != nil {
+				t.Fatal(err)
+			}
+			if err := c.Save(); err != nil {
+				t.Fatal(err)
+			}
+
+			c, err = LoadChecksumCache(cachePath)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(c.entries) != 1 {
+				t.Fatalf("saved cache has %d entries, want 1", len(c.entries))
+			}
+			for k, ce := range c.entries {
+				ce.SHA256 = "cached"
+				c.entries[k] = ce
+			}
+			writeFileAt(t, file, tc.data, tc.mtime)
+			got, err := c.Checksum(file)
+			if err != nil {
+				t.Fatal(err)
+			}
+			want, err := ComputeChecksum(file)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tc.wantHit {
+				want = "cached"
+			}
+			if got != want {
+				t.Errorf("Checksum = %q, want %q", got, want)
+			}
+			if c.dirty == tc.wantHit {
+				t.Errorf("dirty = %v after a hit = %v", c.dirty, tc.wantHit)
+			}
+		})
+	}
+}
+
+func writeFileAt(t *testing.T, path, data string, mtime time.Time) {
+	t.Helper()
+	if err := os.WriteFile(path, []byte(data), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chtimes(path, mtime, mtime); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,30 @@
+package release
+
+// Typed errors let callers tell which stage of a release failed. Each one
+// wraps the underlying error and prints as it does.
+
+// BuildError reports a failed build or missing build output.
+type BuildError struct{ Err error }
+
+func (e *BuildError) Error() string { return e.Err.Error() }
+func (e *BuildError) Unwrap() error { return e.Err }
+
+// UploadError reports a failure on the remote side: mkdir, upload or links.
+type UploadError struct{ Err error }
+
+func (e *UploadError) Error() string { return e.Err.Error() }
+func (e *UploadError) Unwrap() error { return e.Err }
+
+// ChecksumError reports an artifact that could not be hashed or whose hash
+// does not match the expected value.
+type ChecksumError struct{ Err error }
+
+func (e *ChecksumError) Error() string { return e.Err.Error() }
+func (e *ChecksumError) Unwrap() error { return e.Err }
+
+// ManifestError reports a manifest that could not be read, written or
+// does not contain what was asked for.
+type ManifestError struct{ Err error }
+
+func (e *ManifestError) Error() string { return e.Err.Error() }
+func (e *ManifestError) Unwrap() error { return e.Err }
@@ -0,0 +1,216 @@
+package release
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	semver "github.com/Masterminds/semver/v3"
+)
+
+// Feed formats accepted by WriteFeed.
+const (
+	FeedRSS  = "rss"
+	FeedAtom = "atom"
+)
+
+// Feed describes the release feed as a whole. BaseURL, if set, is where
+// the manifest's links are served from; feed readers need absolute links,
+// so without it items carry the relative manifest links only.
+type Feed struct {
+	Title   string
+	BaseURL string
+}
+
+type rssDoc struct {
+	XMLName xml.Name   `xml:"rss"`
+	Version string     `xml:"version,attr"`
+	Channel rssChannel `xml:"channel"`
+}
+
+type rssChannel struct {
+	Title       string    `xml:"title"`
+	Link        string    `xml:"link,omitempty"`
+	Description string    `xml:"description"`
+	Items       []rssItem `xml:"item"`
+}
+
+type rssItem struct {
+	Title       string         `xml:"title"`
+	Link        string         `xml:"link,omitempty"`
+	GUID        rssGUID        `xml:"guid"`
+	PubDate     string         `xml:"pubDate,omitempty"`
+	Description string         `xml:"description"`
+	Enclosures  []rssEnclosure `xml:"enclosure"`
+}
+
+type rssGUID struct {
+	IsPermaLink bool   `xml:"isPermaLink,attr"`
+	Value       string `xml:",chardata"`
+}
+
+type rssEnclosure struct {
+	URL    string `xml:"url,attr"`
+	Length int64  `xml:"length,attr"`
+	Type   string `xml:"type,attr"`
+}
+
+type atomDoc struct {
+	XMLName xml.Name    `xml:"http://www.w3.org/2005/Atom feed"`
+	Title   string      `xml:"title"`
+	ID      string      `xml:"id"`
+	Updated string      `xml:"updated"`
+	Links   []atomLink  `xml:"link"`
+	Entries []atomEntry `xml:"entry"`
+}
+
+type atomEntry struct {
+	Title   string     `xml:"title"`
+	ID      string     `xml:"id"`
+	Updated string     `xml:"updated"`
+	Links   []atomLink `xml:"link"`
+	Content string     `xml:"content"`
+}
+
+type atomLink struct {
+	Href   string `xml:"href,attr"`
+	Rel    string `xml:"rel,attr,omitempty"`
+	Type   string `xml:"type,attr,omitempty"`
+	Length int64  `xml:"length,attr,omitempty"`
+}
+
+// WriteFeed writes entries as an RSS 2.0 or Atom feed to path, newest
+// version first: one item per release, titled with its version, dated
+// with its timestamp and linking to each artifact.
+func WriteFeed(path, format string, f Feed, entries []Entry) error {
+	var b strings.Builder
+	if err := EncodeFeed(&b, format, f, entries); err != nil {
+		return err
+	}
+	return os.WriteFile(path, []byte(b.String()), 0644)
+}
+
+// EncodeFeed is WriteFeed to a writer.
+func EncodeFeed(w io.Writer, format string, f Feed, entries []Entry) error {
+	sorted := feedOrder(entries)
+	var doc any
+	switch format {
+	case FeedRSS:
+		ch := rssChannel{Title: f.Title, Link: f.BaseURL, Description: f.Title}
+		for _, e := range sorted {
+			item := rssItem{
+				Title:       e.Version,
+				GUID:        rssGUID{Value: feedID(f, e)},
+				Description: feedContent(f, e),
+			}
+			if e.Date != 0 {
+				item.PubDate = time.Unix(0, e.Date).UTC().Format(time.RFC1123Z)
+			}
+			for i, l := range e.Links {
+				u := feedURL(f, l.Link)
+				if i == 0 {
+					item.Link = u
+				}
+				item.Enclosures = append(item.Enclosures, rssEnclosure{URL: u, Length: l.Size, Type: "application/zip"})
+			}
+			ch.Items = append(ch.Items, item)
+		}
+		doc = rssDoc{Version: "2.0", Channel: ch}
+	case FeedAtom:
+		feed := atomDoc{Title: f.Title, ID: feedID(f, Entry{})}
+		if f.BaseURL != "" {
+			feed.Links = []atomLink{{Href: f.BaseURL}}
+		}
+		var newest int64
+		for _, e := range sorted {
+			newest = max(newest, e.Date)
+			entry := atomEntry{
+				Title:   e.Version,
+				ID:      feedID(f, e),
+				Updated: feedTime(e.Date),
+				Content: feedContent(f, e),
+			}
+			for _, l := range e.Links {
+				entry.Links = append(entry.Links, atomLink{Href: feedURL(f, l.Link), Rel: "enclosure", Type: "application/zip", Length: l.Size})
+			}
+			feed.Entries = append(feed.Entries, entry)
+		}
+		feed.Updated = feedTime(newest)
+		doc = feed
+	default:
+		return fmt.Errorf("unknown feed format %q (want %s or %s)", format, FeedRSS, FeedAtom)
+	}
+	if _, err := io.WriteString(w, xml.Header); err != nil {
+		return err
+	}
+	enc := xml.NewEncoder(w)
+	enc.Indent("", "  ")
+	if err := enc.Encode(doc); err != nil {
+		return err
+	}
+	_, err := io.WriteString(w, "\n")
+	return err
+}
+
+// feedOrder returns entries with valid versions, highest first.
+func feedOrder(entries []Entry) []Entry {
+	type ver struct {
+		e Entry
+		v *semver.Version
+	}
+	var vs []ver
+	for _, e := range entries {
+		if v, err := semver.NewVersion(strings.TrimSpace(e.Version)); err == nil {
+			vs = append(vs, ver{e, v})
+		}
+	}
+	sort.SliceStable(vs, func(i, j int) bool { return vs[i].v.GreaterThan(vs[j].v) })
+	out := make([]Entry, len(vs))
+	for i, v := range vs {
+		out[i] = v.e
+	}
+	return out
+}
+
+// feedID is a stable ID for the feed (e.Version empty) or one release.
+func feedID(f Feed, e Entry) string {
+	if f.BaseURL != "" {
+		if e.Version == "" {
+			return f.BaseURL
+		}
+		return strings.TrimRight(f.BaseURL, "/") + "/#" + e.Version
+	}
+	id := "urn:relay-release:" + strings.ReplaceAll(f.Title, " ", "-")
+	if e.Version != "" {
+		id += ":" + e.Version
+	}
+	return id
+}
+
+func feedURL(f Feed, link string) string {
+	if f.BaseURL == "" {
+		return filepath.ToSlash(link)
+	}
+	return JoinURL(f.BaseURL, link)
+}
+
+func feedTime(unixNano int64) string {
+	return time.Unix(0, unixNano).UTC().Format(time.RFC3339)
+}
+
+// feedContent is the release notes, if any, followed by the artifacts.
+func feedContent(f Feed, e Entry) string {
+	var b strings.Builder
+	if e.Notes != "" {
+		b.WriteString(e.Notes + "\n\n")
+	}
+	for _, l := range e.Links {
+		fmt.Fprintf(&b, "%s (sha256 %s)\n", feedURL(f, l.Link), l.Checksum)
+	}
+	return strings.TrimSpace(b.String())
+}
@@ -0,0 +1,41 @@
+package release
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// TestFetchEntriesDetectsFormat serves one manifest in each format: a
+// client reads them all without being told how the server wrote it.
+func TestFetchEntriesDetectsFormat(t *testing.T) {
+	const (
+		a = `{"version":"1.0.0","utc-unixnano":1,"links":[{"link":"downloads/1.0.0/client-1.0.0.zip","sha256":"aa"}]}`
+		b = `{"version":"1.1.0","utc-unixnano":2,"links":[{"link":"downloads/1.1.0/client-1.1.0.zip","sha256":"bb"}]}`
+	)
+	manifests := map[string]string{
+		"/manifest.json":         "[\n  " + a + ",\n  " + b + "\n]\n",
+		"/manifest.jsonl":        a + "\n" + b + "\n",
+		"/manifest-wrapped.json": `{"generator":{"name":"relayUpdater"},"entries":[` + a + "," + b + "]}",
+	}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, ok := manifests[r.URL.Path]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write([]byte(body))
+	}))
+	defer srv.Close()
+	for name := range manifests {
+		ents, err := FetchEntries(context.Background(), srv.Client(), srv.URL+name)
+		if err != nil {
+			t.Errorf("%s: %v", name, err)
+			continue
+		}
+		if len(ents) != 2 || ents[1].Version != "1.1.0" || len(ents[1].Links) != 1 {
+			t.Errorf("%s: got %+v, want both entries", name, ents)
+		}
+	}
+}
@@ -0,0 +1,50 @@
+package release
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+// WriteGzip writes path+".gz", a gzipped copy of the file at path, and reads
+// it back to make sure it decompresses to the same bytes. The gzip header
+// carries no time stamp, so the same manifest always gives the same file.
+func WriteGzip(path string) (string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	var buf bytes.Buffer
+	zw, err := gzip.NewWriterLevel(&buf, gzip.BestCompression)
+	if err != nil {
+		return "", err
+	}
+	zw.Name = filepath.Base(path)
+	if _, err := zw.Write(data); err != nil {
+		return "", err
+	}
+	if err := zw.Close(); err != nil {
+		return "", err
+	}
+
+	zr, err := gzip.NewReader(bytes.NewReader(buf.Bytes()))
+	if err != nil {
+		return "", err
+	}
+	back, err := io.ReadAll(zr)
+	if err != nil {
+		return "", err
+	}
+	if !bytes.Equal(back, data) {
+		return "", fmt.Errorf("%s.gz does not decompress to %s", path, path)
+	}
+
+	gz := path + ".gz"
+	if err := os.WriteFile(gz, buf.Bytes(), 0644); err != nil {
+		return "", err
+	}
+	return gz, nil
+}
@@ -0,0 +1,131 @@
+package release
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+)
+
+// LocalTransport publishes into directories on this machine, for tests and
+// local or air-gapped mirrors. Remote commands run under sh -c, so the
+// checks that query the server work unchanged.
+type LocalTransport struct {
+	// Stdout and Stderr receive the output of commands; nil means the
+	// process's own.
+	Stdout, Stderr io.Writer
+
+	// RelativeSymlinks makes Symlink store targets relative to the link;
+	// see SymlinkTarget.
+	RelativeSymlinks bool
+}
+
+var _ Transport = (*LocalTransport)(nil)
+
+func (t *LocalTransport) stdout() io.Writer {
+	if t.Stdout != nil {
+		return t.Stdout
+	}
+	return os.Stdout
+}
+
+func (t *LocalTransport) stderr() io.Writer {
+	if t.Stderr != nil {
+		return t.Stderr
+	}
+	return os.Stderr
+}
+
+func (t *LocalTransport) MkdirAll(ctx context.Context, dir string) error {
+	return os.MkdirAll(dir, 0755)
+}
+
+// Upload copies each local into dir under a temporary name and renames it
+// into place.
+func (t *LocalTransport) Upload(ctx context.Context, dir string, locals ...string) error {
+	for _, local := range locals {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		dst := filepath.Join(dir, filepath.Base(local))
+		if err := copyInto(local, dst); err != nil {
+			return fmt.Errorf("copy %s to %s failed: %w", local, dir, err)
+		}
+	}
+	return nil
+}
+
+// UploadFrom writes r to remotePath through a temporary file.
+func (t *LocalTransport) UploadFrom(ctx context.Context, r io.Reader, remotePath string) error {
+	return writeInto(r, remotePath)
+}
+
+func copyInto(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	return writeInto(in, dst)
+}
+
+func writeInto(in io.Reader, dst string) error {
+	tmp := filepath.Join(filepath.Dir(dst), "."+filepath.Base(dst)+".tmp")
+	out, err := os.OpenFile(tmp, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		os.Remove(tmp)
+		return err
+	}
+	if err := out.Close(); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return os.Rename(tmp, dst)
+}
+
+// Symlink replaces link with a symlink to target, atomically like ln -sfn.
+func (t *LocalTransport) Symlink(ctx context.Context, target, link string) error {
+	tmp := filepath.Join(filepath.Dir(link), "."+filepath.Base(link)+".tmp")
+	os.Remove(tmp)
+	if err := os.Symlink(SymlinkTarget(target, link, t.RelativeSymlinks), tmp); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, link); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return nil
+}
+
+func (t *LocalTransport) command(ctx context.Context, cmd string) *exec.Cmd {
+	c := exec.CommandContext(ctx, "sh", "-c", cmd)
+	c.Stdout = t.stdout()
+	c.Stderr = t.stderr()
+	return c
+}
+
+func (t *LocalTransport) Run(ctx context.Context, cmd string) error {
+	return RunCommand(ctx, t.command(ctx, cmd))
+}
+
+func (t *LocalTransport) Output(ctx context.Context, cmd string) ([]byte, error) {
+	c := t.command(ctx, cmd)
+	var out bytes.Buffer
+	c.Stdout = &out
+	err := RunCommand(ctx, c)
+	return out.Bytes(), err
+}
+
+// Stream executes cmd and copies its standard output to w.
+func (t *LocalTransport) Stream(ctx context.Context, cmd string, w io.Writer) error {
+	c := t.command(ctx, cmd)
+	c.Stdout = w
+	return RunCommand(ctx, c)
+}
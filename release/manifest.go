@@ -0,0 +1,704 @@
+// Package release holds the reusable parts of relayUpdater: the manifest
+// format, artifact checksums and the transport used to publish a release.
+package release
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+
+	semver "github.com/Masterminds/semver/v3"
+)
+
+// Manifest formats: a single JSON array, one JSON entry per line, or an
+// object holding the array next to a "generator" field.
+const (
+	FormatJSON    = "json"
+	FormatJSONL   = "jsonl"
+	FormatWrapped = "wrapped"
+)
+
+// GeneratorInfo names the tool that wrote a wrapped manifest.
+type GeneratorInfo struct {
+	Name    string `json:"name"`
+	Version string `json:"version"`
+}
+
+// ManifestOptions controls how manifests are written. The zero value
+// writes compact JSON with hex checksums under "sha256", and wrapped
+// manifests without generator or meta.
+type ManifestOptions struct {
+	// Indent is the per-level indentation of json and wrapped manifests;
+	// "" writes them on one line. JSON-lines manifests are always compact.
+	Indent string
+	// ChecksumKeys lists the JSON keys the checksum is written under; nil
+	// means "sha256". "hash" is only understood by older clients.
+	ChecksumKeys []string
+	// ChecksumEncoding is EncodingHex or EncodingSRI; "" means hex.
+	ChecksumEncoding string
+	// Generator is written into wrapped manifests; the bare array and
+	// JSON-lines formats have nowhere to put it.
+	Generator *GeneratorInfo
+	// Meta holds manifest-wide values (support URL, check interval, ...)
+	// written as the "meta" object of wrapped manifests.
+	Meta map[string]string
+}
+
+func (o ManifestOptions) checksumKeys() []string {
+	if o.ChecksumKeys == nil {
+		return []string{"sha256"}
+	}
+	return o.ChecksumKeys
+}
+
+// ChecksumAlgorithm is the hash every checksum in a manifest is taken
+// with. Wrapped manifests name it as "checksum-algorithm"; readers must
+// assume it when the key is absent, as the other formats cannot carry it.
+const ChecksumAlgorithm = "sha256"
+
+// wrappedManifest is the on-disk form of FormatWrapped, as read.
+type wrappedManifest struct {
+	Generator *GeneratorInfo    `json:"generator,omitempty"`
+	Meta      map[string]string `json:"meta,omitempty"`
+	Algorithm string            `json:"checksum-algorithm,omitempty"`
+	Entries   []Entry           `json:"entries"`
+}
+
+// wrappedOut is wrappedManifest with the entries already encoded.
+type wrappedOut struct {
+	Generator *GeneratorInfo    `json:"generator,omitempty"`
+	Meta      map[string]string `json:"meta,omitempty"`
+	Algorithm string            `json:"checksum-algorithm,omitempty"`
+	Entries   []json.RawMessage `json:"entries"`
+}
+
+// checkAlgorithm rejects a manifest whose checksums are not
+// ChecksumAlgorithm; "" means it was not stated.
+func checkAlgorithm(alg string) error {
+	if alg != "" && !strings.EqualFold(alg, ChecksumAlgorithm) {
+		return fmt.Errorf("unsupported checksum-algorithm %q (this version reads %s)", alg, ChecksumAlgorithm)
+	}
+	return nil
+}
+
+// ReadMeta returns the "meta" object of the manifest at path, or nil if the
+// file does not exist or is not in FormatWrapped.
+func ReadMeta(path string) (map[string]string, error) {
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if DetectFormat(data) != FormatWrapped {
+		return nil, nil
+	}
+	var w wrappedManifest
+	if err := json.Unmarshal(data, &w); err != nil {
+		return nil, err
+	}
+	return w.Meta, nil
+}
+
+// DownloadInfo is one artifact of a release.
+type DownloadInfo struct {
+	Link     string
+	Checksum string
+	// Mirrors lists full URLs of the same file on each mirror; it is only
+	// set when there is more than one.
+	Mirrors []string
+	// Size is the file size in bytes; 0 means unknown.
+	Size int64
+	// Channel is the release channel of this artifact, such as "beta";
+	// "" is the stable channel.
+	Channel string
+	// ContentHash is ContentChecksum of the archive, if it was asked for.
+	ContentHash string
+	// MinOSVersion is the oldest OS release the artifact runs on, in
+	// whatever form its platform uses (e.g. "11.0" or "10.0.17763"); ""
+	// means not stated.
+	MinOSVersion string
+}
+
+// Checksum encodings: lowercase hex, or subresource-integrity style
+// "sha256-<base64>".
+const (
+	EncodingHex = "hex"
+	EncodingSRI = "sri"
+)
+
+// EncodeChecksum formats a hex sha256 in encoding. DownloadInfo.Checksum
+// is always hex in memory, and reading accepts either encoding.
+func EncodeChecksum(sum, encoding string) string {
+	if encoding != EncodingSRI || sum == "" {
+		return sum
+	}
+	raw, err := hex.DecodeString(sum)
+	if err != nil {
+		return sum
+	}
+	return "sha256-" + base64.StdEncoding.EncodeToString(raw)
+}
+
+// DecodeChecksum turns a checksum in either encoding back into hex.
+func DecodeChecksum(s string) (string, error) {
+	b64, ok := strings.CutPrefix(s, "sha256-")
+	if !ok {
+		// an SRI digest of another algorithm must not pass for hex
+		if alg, _, sri := strings.Cut(s, "-"); sri && checkAlgorithm(alg) != nil {
+			return "", checkAlgorithm(alg)
+		}
+		return strings.ToLower(s), nil
+	}
+	raw, err := base64.StdEncoding.DecodeString(b64)
+	if err != nil || len(raw) != 32 {
+		return "", fmt.Errorf("invalid SRI checksum %q", s)
+	}
+	return hex.EncodeToString(raw), nil
+}
+
+// downloadInfoJSON is the on-disk form of DownloadInfo, as read.
+type downloadInfoJSON struct {
+	Link        string   `json:"link"`
+	SHA256      string   `json:"sha256,omitempty"`
+	Hash        string   `json:"hash,omitempty"`
+	Mirrors     []string `json:"mirrors,omitempty"`
+	Size        int64    `json:"size,omitempty"`
+	Channel     string   `json:"channel,omitempty"`
+	ContentHash string   `json:"content-sha256,omitempty"`
+	MinOS       string   `json:"min-os-version,omitempty"`
+}
+
+// MarshalJSON writes d with the default ManifestOptions.
+func (d DownloadInfo) MarshalJSON() ([]byte, error) {
+	return ManifestOptions{}.marshalLink(d)
+}
+
+// marshalLink writes the keys in a fixed order: link, sha256, hash, mirrors,
+// size, channel, content-sha256, min-os-version. Keys added later go at the
+// end.
+func (o ManifestOptions) marshalLink(d DownloadInfo) ([]byte, error) {
+	fields := []jsonField{{"link", d.Link}}
+	if sum := EncodeChecksum(d.Checksum, o.ChecksumEncoding); sum != "" {
+		for _, key := range []string{"sha256", "hash"} {
+			if slices.Contains(o.checksumKeys(), key) {
+				fields = append(fields, jsonField{key, sum})
+			}
+		}
+	}
+	if len(d.Mirrors) > 0 {
+		fields = append(fields, jsonField{"mirrors", d.Mirrors})
+	}
+	if d.Size != 0 {
+		fields = append(fields, jsonField{"size", d.Size})
+	}
+	if d.Channel != "" {
+		fields = append(fields, jsonField{"channel", d.Channel})
+	}
+	if d.ContentHash != "" {
+		fields = append(fields, jsonField{"content-sha256", d.ContentHash})
+	}
+	if d.MinOSVersion != "" {
+		fields = append(fields, jsonField{"min-os-version", d.MinOSVersion})
+	}
+	return marshalOrdered(fields)
+}
+
+func (d *DownloadInfo) UnmarshalJSON(data []byte) error {
+	var in downloadInfoJSON
+	if err := json.Unmarshal(data, &in); err != nil {
+		return err
+	}
+	d.Link = in.Link
+	d.Mirrors = in.Mirrors
+	d.Size = in.Size
+	d.Channel = in.Channel
+	d.ContentHash = in.ContentHash
+	d.MinOSVersion = in.MinOS
+	sum := in.SHA256
+	if sum == "" {
+		sum = in.Hash
+	}
+	var err error
+	d.Checksum, err = DecodeChecksum(sum)
+	return err
+}
+
+// MirrorURLs returns link on each of baseURLs, or nil if there are fewer
+// than two mirrors (a single location needs no list).
+func MirrorURLs(baseURLs []string, link string) []string {
+	if len(baseURLs) < 2 {
+		return nil
+	}
+	urls := make([]string, len(baseURLs))
+	for i, base := range baseURLs {
+		urls[i] = JoinURL(base, link)
+	}
+	return urls
+}
+
+// JoinURL appends a manifest link to a base URL.
+func JoinURL(base, link string) string {
+	return strings.TrimRight(base, "/") + "/" + strings.TrimLeft(filepath.ToSlash(link), "/")
+}
+
+// ParseChecksumKey maps a -checksum-key value to the keys to emit.
+func ParseChecksumKey(s string) ([]string, error) {
+	switch s {
+	case "sha256", "hash":
+		return []string{s}, nil
+	case "both":
+		return []string{"sha256", "hash"}, nil
+	}
+	return nil, fmt.Errorf("unknown checksum key %q (want sha256, hash or both)", s)
+}
+
+// Entry is one released version in the manifest.
+type Entry struct {
+	Version string         `json:"version"`
+	Date    int64          `json:"utc-unixnano"`
+	Links   []DownloadInfo `json:"links"`
+	Latest  []DownloadInfo `json:"latest,omitempty"`
+	// Missing lists the targets a partial release was published without.
+	Missing []string `json:"missing,omitempty"`
+	// RolloutPercent, if set, is the share of clients (0-100) that should
+	// auto-update to this version; clients treat a missing value as 100.
+	RolloutPercent *int `json:"rollout-percent,omitempty"`
+	// DirChecksum covers every link at once; see DirChecksum.
+	DirChecksum string `json:"dir-sha256,omitempty"`
+	// Notes holds handwritten release notes (markdown or plain text).
+	Notes string `json:"notes,omitempty"`
+	// AnalyticsURL is where clients POST after installing this version.
+	AnalyticsURL string `json:"analytics-url,omitempty"`
+	// HumanDate is set for entries that carry "date-rfc3339". Only those
+	// are written with it, so upgrading does not touch every entry; -migrate
+	// sets it on all of them.
+	HumanDate bool `json:"-"`
+}
+
+func (e *Entry) UnmarshalJSON(data []byte) error {
+	// plain has Entry's fields without this method
+	type plain Entry
+	var in struct {
+		plain
+		DateRFC3339 string `json:"date-rfc3339"`
+	}
+	if err := json.Unmarshal(data, &in); err != nil {
+		return err
+	}
+	*e = Entry(in.plain)
+	e.HumanDate = in.DateRFC3339 != ""
+	return nil
+}
+
+// MarshalJSON writes e with the default ManifestOptions.
+func (e Entry) MarshalJSON() ([]byte, error) {
+	return ManifestOptions{}.marshalEntry(e)
+}
+
+// marshalEntry writes the keys in a fixed order: version, utc-unixnano,
+// links, latest, missing, rollout-percent, dir-sha256, notes, analytics-url,
+// date-rfc3339.
+// Empty optional keys are left out, and keys added later go at the end, so
+// a new field never moves the keys of entries that do not use it.
+//
+// date-rfc3339 repeats Date for people reading the manifest, on entries
+// with HumanDate set; it is derived, so reading only notes that it is there.
+func (o ManifestOptions) marshalEntry(e Entry) ([]byte, error) {
+	links, err := o.marshalLinks(e.Links)
+	if err != nil {
+		return nil, err
+	}
+	fields := []jsonField{
+		{"version", e.Version},
+		{"utc-unixnano", e.Date},
+		{"links", links},
+	}
+	if len(e.Latest) > 0 {
+		latest, err := o.marshalLinks(e.Latest)
+		if err != nil {
+			return nil, err
+		}
+		fields = append(fields, jsonField{"latest", latest})
+	}
+	if len(e.Missing) > 0 {
+		fields = append(fields, jsonField{"missing", e.Missing})
+	}
+	if e.RolloutPercent != nil {
+		fields = append(fields, jsonField{"rollout-percent", *e.RolloutPercent})
+	}
+	if e.DirChecksum != "" {
+		fields = append(fields, jsonField{"dir-sha256", e.DirChecksum})
+	}
+	if e.Notes != "" {
+		fields = append(fields, jsonField{"notes", e.Notes})
+	}
+	if e.AnalyticsURL != "" {
+		fields = append(fields, jsonField{"analytics-url", e.AnalyticsURL})
+	}
+	if e.HumanDate && e.Date != 0 {
+		fields = append(fields, jsonField{"date-rfc3339", time.Unix(0, e.Date).UTC().Format(time.RFC3339)})
+	}
+	return marshalOrdered(fields)
+}
+
+func (o ManifestOptions) marshalLinks(links []DownloadInfo) ([]json.RawMessage, error) {
+	out := make([]json.RawMessage, len(links))
+	for i, l := range links {
+		b, err := o.marshalLink(l)
+		if err != nil {
+			return nil, err
+		}
+		out[i] = b
+	}
+	return out, nil
+}
+
+// jsonField is one key of an object written by marshalOrdered.
+type jsonField struct {
+	key   string
+	value any
+}
+
+// marshalOrdered writes fields as a JSON object in the given order, which
+// encoding/json only guarantees for struct declaration order.
+func marshalOrdered(fields []jsonField) ([]byte, error) {
+	var buf bytes.Buffer
+	buf.WriteByte('{')
+	for i, f := range fields {
+		if i > 0 {
+			buf.WriteByte(',')
+		}
+		key, err := json.Marshal(f.key)
+		if err != nil {
+			return nil, err
+		}
+		val, err := json.Marshal(f.value)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", f.key, err)
+		}
+		buf.Write(key)
+		buf.WriteByte(':')
+		buf.Write(val)
+	}
+	buf.WriteByte('}')
+	return buf.Bytes(), nil
+}
+
+// DetectFormat guesses the format of manifest data: an array is
+// FormatJSON, an object with "entries" is FormatWrapped, and anything else
+// is read as FormatJSONL.
+func DetectFormat(data []byte) string {
+	trimmed := bytes.TrimSpace(data)
+	if len(trimmed) == 0 || trimmed[0] == '[' {
+		return FormatJSON
+	}
+	var probe struct {
+		Entries json.RawMessage `json:"entries"`
+	}
+	if json.Unmarshal(trimmed, &probe) == nil && probe.Entries != nil {
+		return FormatWrapped
+	}
+	return FormatJSONL
+}
+
+// ReadEntries loads the manifest at path, creating an empty one if it
+// does not exist yet.
+func ReadEntries(path, format string) ([]Entry, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			if err := WriteEntries(path, format, []Entry{}, ManifestOptions{}); err != nil {
+				return nil, err
+			}
+			return []Entry{}, nil
+		}
+		return nil, err
+	}
+	return ParseEntries(data, format)
+}
+
+// ParseEntries decodes a manifest in the given format.
+func ParseEntries(data []byte, format string) ([]Entry, error) {
+	switch format {
+	case FormatJSONL:
+		return ParseEntryLines(data)
+	case FormatWrapped:
+		var w wrappedManifest
+		if err := json.Unmarshal(data, &w); err != nil {
+			return nil, err
+		}
+		if err := checkAlgorithm(w.Algorithm); err != nil {
+			return nil, err
+		}
+		if w.Entries == nil {
+			w.Entries = []Entry{}
+		}
+		return w.Entries, nil
+	}
+	var ents []Entry
+	if err := json.Unmarshal(data, &ents); err != nil {
+		return nil, err
+	}
+	return ents, nil
+}
+
+// ParseEntryLines decodes a JSON-lines manifest, skipping blank lines.
+func ParseEntryLines(data []byte) ([]Entry, error) {
+	ents := []Entry{}
+	sc := bufio.NewScanner(bytes.NewReader(data))
+	sc.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for n := 1; sc.Scan(); n++ {
+		line := bytes.TrimSpace(sc.Bytes())
+		if len(line) == 0 {
+			continue
+		}
+		var e Entry
+		if err := json.Unmarshal(line, &e); err != nil {
+			return nil, fmt.Errorf("line %d: %w", n, err)
+		}
+		ents = append(ents, e)
+	}
+	return ents, sc.Err()
+}
+
+// WriteEntries replaces the manifest at path with ents.
+func WriteEntries(path, format string, ents []Entry, o ManifestOptions) error {
+	out, err := EncodeEntries(format, ents, o)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, out, 0644)
+}
+
+// EncodeEntries returns the manifest WriteEntries would write.
+func EncodeEntries(format string, ents []Entry, o ManifestOptions) ([]byte, error) {
+	raw := make([]json.RawMessage, len(ents))
+	for i, e := range ents {
+		b, err := o.marshalEntry(e)
+		if err != nil {
+			return nil, err
+		}
+		raw[i] = b
+	}
+	if format == FormatJSONL {
+		var buf bytes.Buffer
+		for _, line := range raw {
+			buf.Write(line)
+			buf.WriteByte('\n')
+		}
+		return buf.Bytes(), nil
+	}
+	var v any = raw
+	if format == FormatWrapped {
+		v = wrappedOut{Generator: o.Generator, Meta: o.Meta, Algorithm: ChecksumAlgorithm, Entries: raw}
+	}
+	if o.Indent == "" {
+		return json.Marshal(v)
+	}
+	return json.MarshalIndent(v, "", o.Indent)
+}
+
+// AppendEntryLine adds e to the end of a JSON-lines manifest without
+// rewriting the existing entries.
+func AppendEntryLine(path string, e Entry, o ManifestOptions) error {
+	line, err := o.marshalEntry(e)
+	if err != nil {
+		return err
+	}
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(append(line, '\n')); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// UpsertEntry replaces the entry with newEntry's version, or appends it.
+// A rollout percentage on the old entry survives unless newEntry sets one.
+// The new entry carries date-rfc3339 if the manifest's entries do.
+func UpsertEntry(entries []Entry, newEntry Entry) []Entry {
+	newEntry.HumanDate = newEntry.HumanDate || humanDates(entries)
+	for i, e := range entries {
+		if SameVersion(e.Version, newEntry.Version) {
+			if newEntry.RolloutPercent == nil {
+				newEntry.RolloutPercent = e.RolloutPercent
+			}
+			entries[i] = newEntry
+			return entries
+		}
+	}
+	return append(entries, newEntry)
+}
+
+// humanDates reports whether the newest entry of a manifest carries
+// date-rfc3339, which is true of every entry once it has been migrated.
+func humanDates(entries []Entry) bool {
+	return len(entries) > 0 && entries[len(entries)-1].HumanDate
+}
+
+// EntriesSince returns the entries released at or after t, in their
+// order. Entries without a date are dropped.
+func EntriesSince(entries []Entry, t time.Time) []Entry {
+	var out []Entry
+	for _, e := range entries {
+		if e.Date != 0 && e.Date >= t.UnixNano() {
+			out = append(out, e)
+		}
+	}
+	return out
+}
+
+// MergeEntry adds newEntry's links to the existing entry with the same
+// version, replacing links that share a filename. The entry's date is kept.
+// If no such entry exists, newEntry is appended as-is.
+func MergeEntry(entries []Entry, newEntry Entry) []Entry {
+	i := FindEntry(entries, newEntry.Version)
+	if i < 0 {
+		newEntry.HumanDate = newEntry.HumanDate || humanDates(entries)
+		return append(entries, newEntry)
+	}
+	links := entries[i].Links
+	for _, nl := range newEntry.Links {
+		replaced := false
+		for j, ol := range links {
+			if filepath.Base(ol.Link) == filepath.Base(nl.Link) {
+				links[j] = nl
+				replaced = true
+				break
+			}
+		}
+		if !replaced {
+			links = append(links, nl)
+		}
+	}
+	entries[i].Links = links
+	return entries
+}
+
+// LatestLinkPaths returns the versioned file and its "-latest" alias for
+// artifact f under base, e.g. base/0.2.5/client-0.2.5.zip and
+// base/client-latest.zip.
+func LatestLinkPaths(base, version, f string) (target, link string) {
+	return LatestLinkPathsIn(base, version, f, "")
+}
+
+// LatestLinkPathsIn is LatestLinkPaths for an artifact in channel. Stable
+// artifacts (channel "") keep "<name>-latest.zip"; others get
+// "<name>-<channel>-latest.zip" so channels do not overwrite each other.
+func LatestLinkPathsIn(base, version, f, channel string) (target, link string) {
+	// strip "-<version>.zip" → get "client"
+	generic := strings.TrimSuffix(f, "-"+version+".zip")
+	if channel != "" {
+		generic += "-" + channel
+	}
+	return filepath.Join(base, version, f), filepath.Join(base, generic+"-latest.zip")
+}
+
+// RecordLatestAliases lists the "-latest" alias (under dlDir) of every
+// link of version's entry in its Latest field and clears Latest on all
+// other entries. An alias resolves to the versioned file, so it carries the
+// same checksum.
+func RecordLatestAliases(entries []Entry, version, dlDir string) []Entry {
+	for i := range entries {
+		entries[i].Latest = nil
+		if !SameVersion(entries[i].Version, version) {
+			continue
+		}
+		for _, l := range entries[i].Links {
+			_, alias := LatestLinkPathsIn(dlDir, version, filepath.Base(l.Link), l.Channel)
+			entries[i].Latest = append(entries[i].Latest, DownloadInfo{Link: alias, Checksum: l.Checksum, Channel: l.Channel})
+		}
+	}
+	return entries
+}
+
+// ParseVersion parses a version as semver after trimming surrounding
+// whitespace. A leading "v" is accepted; a blank version is an error of its
+// own rather than semver's.
+func ParseVersion(s string) (*semver.Version, error) {
+	if s = strings.TrimSpace(s); s == "" {
+		return nil, errors.New("version is empty")
+	}
+	return semver.NewVersion(s)
+}
+
+// FindEntry returns the index of the entry for version, or -1. A "v"
+// prefix on either side is ignored.
+func FindEntry(entries []Entry, version string) int {
+	for i, e := range entries {
+		if SameVersion(e.Version, version) {
+			return i
+		}
+	}
+	return -1
+}
+
+// SameVersion reports whether a and b name the same release, comparing by
+// semver so that "v1.2.3" and "1.2.3" match.
+func SameVersion(a, b string) bool {
+	va, errA := semver.NewVersion(strings.TrimSpace(a))
+	vb, errB := semver.NewVersion(strings.TrimSpace(b))
+	if errA != nil || errB != nil {
+		return a == b
+	}
+	return va.Equal(vb)
+}
+
+// IsHighest reports whether version is the newest release in entries.
+func IsHighest(entries []Entry, version string) bool {
+	return SameVersion(HighestVersion(entries).String(), version)
+}
+
+// NewestEntries returns the n entries with the highest versions, in their
+// original order. Entries whose version is not valid semver rank lowest.
+func NewestEntries(entries []Entry, n int) []Entry {
+	if len(entries) <= n {
+		return entries
+	}
+	idx := make([]int, len(entries))
+	vers := make([]*semver.Version, len(entries))
+	for i, e := range entries {
+		idx[i] = i
+		vers[i], _ = semver.NewVersion(strings.TrimSpace(e.Version))
+	}
+	sort.SliceStable(idx, func(a, b int) bool {
+		va, vb := vers[idx[a]], vers[idx[b]]
+		if va == nil || vb == nil {
+			return vb == nil && va != nil
+		}
+		return va.GreaterThan(vb)
+	})
+	keep := idx[:n]
+	sort.Ints(keep)
+	out := make([]Entry, 0, n)
+	for _, i := range keep {
+		out = append(out, entries[i])
+	}
+	return out
+}
+
+// HighestVersion returns the greatest valid semver in entries, or 0.0.0.
+func HighestVersion(entries []Entry) *semver.Version {
+	highest := semver.MustParse("0.0.0")
+	for _, e := range entries {
+		if v, err := ParseVersion(e.Version); err == nil && v.GreaterThan(highest) {
+			highest = v
+		}
+	}
+	return highest
+}
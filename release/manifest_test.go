@@ -0,0 +1,280 @@
+package release
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestParseVersion(t *testing.T) {
+	for _, tc := range []struct {
+		in, want string // want "" means an error
+	}{
+		{"1.2.3", "1.2.3"},
+		{"  1.2.3\t", "1.2.3"},
+		{"v1.2.3", "1.2.3"},
+		{" v1.2.3 ", "1.2.3"},
+		{"1.2", "1.2.0"},
+		{"", ""},
+		{"   ", ""},
+		{"\n", ""},
+		{"v", ""},
+		{"1.2.3 beta", ""},
+	} {
+		v, err := ParseVersion(tc.in)
+		switch {
+		case tc.want == "" && err == nil:
+			t.Errorf("ParseVersion(%q) = %s, want an error", tc.in, v)
+		case tc.want != "" && err != nil:
+			t.Errorf("ParseVersion(%q): %v", tc.in, err)
+		case tc.want != "" && v.String() != tc.want:
+			t.Errorf("ParseVersion(%q) = %s, want %s", tc.in, v, tc.want)
+		}
+	}
+	if _, err := ParseVersion(" "); err == nil || err.Error() != "version is empty" {
+		t.Errorf("blank version error = %v, want \"version is empty\"", err)
+	}
+}
+
+func TestHighestVersionSkipsBadVersions(t *testing.T) {
+	for _, tc := range []struct {
+		versions []string
+		want     string
+	}{
+		{nil, "0.0.0"},
+		{[]string{"", "  "}, "0.0.0"},
+		{[]string{"1.0.0", "", "1.1.0"}, "1.1.0"},
+		{[]string{" 1.2.0 ", "1.1.0"}, "1.2.0"},
+		{[]string{"v1.3.0", "1.2.0"}, "1.3.0"},
+		{[]string{"1.0.0", "garbage", "\t"}, "1.0.0"},
+	} {
+		var entries []Entry
+		for _, v := range tc.versions {
+			entries = append(entries, Entry{Version: v})
+		}
+		if got := HighestVersion(entries).String(); got != tc.want {
+			t.Errorf("HighestVersion(%q) = %s, want %s", tc.versions, got, tc.want)
+		}
+	}
+}
+
+// BenchmarkRelease10k compares adding a release to a 10k-entry manifest by
+// rewriting the whole file (json) with appending one line (jsonl).
+func BenchmarkRelease10k(b *testing.B) {
+	ents := make([]Entry, 10000)
+	for i := range ents {
+		v := fmt.Sprintf("1.%d.%d", i/100, i%100)
+		ents[i] = Entry{
+			Version: v,
+			Date:    1700000000000000000 + int64(i),
+			Links:   []DownloadInfo{{Link: "downloads/" + v + "/client-" + v + ".zip", Checksum: "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"}},
+		}
+	}
+	next := Entry{Version: "2.0.0", Date: 1800000000000000000, Links: []DownloadInfo{{Link: "downloads/2.0.0/client-2.0.0.zip"}}}
+
+	o := ManifestOptions{Indent: "  "}
+	b.Run("json-rewrite", func(b *testing.B) {
+		path := filepath.Join(b.TempDir(), "m.json")
+		if err := WriteEntries(path, FormatJSON, ents, o); err != nil {
+			b.Fatal(err)
+		}
+		b.ResetTimer()
+		for range b.N {
+			got, err := ReadEntries(path, FormatJSON)
+			if err != nil {
+				b.Fatal(err)
+			}
+			if err := WriteEntries(path, FormatJSON, append(got[:len(ents)], next), o); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+	b.Run("jsonl-append", func(b *testing.B) {
+		path := filepath.Join(b.TempDir(), "m.jsonl")
+		if err := WriteEntries(path, FormatJSONL, ents, o); err != nil {
+			b.Fatal(err)
+		}
+		b.ResetTimer()
+		for range b.N {
+			if err := AppendEntryLine(path, next, o); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
+
+func TestMixedVersionPrefixes(t *testing.T) {
+	manifest := `[
+{"version":"1.0.0","utc-unixnano":1,"links":[{"link":"downloads/1.0.0/client-1.0.0.zip"}]},
+{"version":"v1.1.0","utc-unixnano":2,"links":[{"link":"downloads/v1.1.0/client-v1.1.0.zip"}]},
+{"version":"1.0.5","utc-unixnano":3,"links":[]},
+{"version":"v1.2.0","utc-unixnano":4,"links":[{"link":"downloads/v1.2.0/client-v1.2.0.zip"}]}
+]`
+	var entries []Entry
+	if err := json.Unmarshal([]byte(manifest), &entries); err != nil {
+		t.Fatal(err)
+	}
+	if got := HighestVersion(entries).String(); got != "1.2.0" {
+		t.Errorf("HighestVersion = %s, want 1.2.0", got)
+	}
+	for _, v := range []string{"v1.2.0", "1.2.0"} {
+		if !IsHighest(entries, v) {
+			t.Errorf("IsHighest(%s) = false", v)
+		}
+	}
+	for _, tc := range []struct {
+		version string
+		want    int
+	}{
+		{"1.0.0", 0}, {"v1.0.0", 0}, {"1.1.0", 1}, {"v1.1.0", 1}, {"v1.0.5", 2}, {"1.3.0", -1},
+	} {
+		if got := FindEntry(entries, tc.version); got != tc.want {
+			t.Errorf("FindEntry(%s) = %d, want %d", tc.version, got, tc.want)
+		}
+	}
+	var newest []string
+	for _, e := range NewestEntries(entries, 2) {
+		newest = append(newest, e.Version)
+	}
+	if strings.Join(newest, ",") != "v1.1.0,v1.2.0" {
+		t.Errorf("NewestEntries(2) = %v, want [v1.1.0 v1.2.0]", newest)
+	}
+
+	// the -latest alias strips the stored spelling of the version
+	_, link := LatestLinkPaths("downloads", "v1.2.0", "client-v1.2.0.zip")
+	if link != filepath.Join("downloads", "client-latest.zip") {
+		t.Errorf("latest link for a prefixed version = %s", link)
+	}
+
+	// a release of "1.1.0" replaces the prefixed entry instead of adding one
+	entries = UpsertEntry(entries, Entry{Version: "1.1.0", Date: 5})
+	if len(entries) != 4 || entries[1].Date != 5 {
+		t.Errorf("UpsertEntry(1.1.0) did not replace v1.1.0: %+v", entries)
+	}
+	entries = MergeEntry(entries, Entry{Version: "v1.0.0", Links: []DownloadInfo{{Link: "downloads/1.0.0/client-1.0.0-mac.zip"}}})
+	if len(entries) != 4 || len(entries[0].Links) != 2 {
+		t.Errorf("MergeEntry(v1.0.0) did not add to 1.0.0: %+v", entries)
+	}
+}
+
+var update = flag.Bool("update", false, "rewrite the golden files in testdata")
+
+// goldenEntries uses every key an entry and a link can have.
+func goldenEntries() []Entry {
+	rollout := 25
+	return []Entry{
+		{
+			Version: "1.0.0",
+			Date:    1700000000000000000,
+			Links:   []DownloadInfo{{Link: "downloads/1.0.0/client-1.0.0.zip", Checksum: "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"}},
+		},
+		{
+			Version: "1.1.0",
+			Date:    1710000000000000000,
+			Links: []DownloadInfo{{
+				Link:         "downloads/1.1.0/client-1.1.0.zip",
+				Checksum:     "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855",
+				Mirrors:      []string{"https://a.example/downloads/1.1.0/client-1.1.0.zip", "https://b.example/downloads/1.1.0/client-1.1.0.zip"},
+				Size:         1234,
+				Channel:      "beta",
+				ContentHash:  "5feceb66ffc86f38d952786c6d696c79c2dbc239dd4e91b46729d73a27fb57e9",
+				MinOSVersion: "11.0",
+			}},
+			Latest:         []DownloadInfo{{Link: "downloads/client-beta-latest.zip", Checksum: "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855", Channel: "beta"}},
+			Missing:        []string{"win"},
+			RolloutPercent: &rollout,
+			DirChecksum:    "6b86b273ff34fce19d6b804eff5a3f5747ada4eaa22f1d49c01e52ddb7875b4b",
+			Notes:          "Fixes the relay timeout.",
+			AnalyticsURL:   "https://stats.example/install",
+			HumanDate:      true,
+		},
+	}
+}
+
+// TestManifestGolden pins the bytes of each format, including key order,
+// so a change that would rewrite every manifest shows up here. Run with
+// -update to accept a deliberate change.
+func TestManifestGolden(t *testing.T) {
+	for _, tc := range []struct {
+		file, format string
+		opts         ManifestOptions
+	}{
+		{"manifest.json", FormatJSON, ManifestOptions{Indent: "  "}},
+		{"manifest.jsonl", FormatJSONL, ManifestOptions{}},
+		{"manifest-wrapped.json", FormatWrapped, ManifestOptions{
+			Indent:    "  ",
+			Generator: &GeneratorInfo{Name: "relayUpdater", Version: "dev"},
+			Meta:      map[string]string{"support-url": "https://example.com/help"},
+		}},
+		{"manifest-both-sri.json", FormatJSON, ManifestOptions{ChecksumKeys: []string{"sha256", "hash"}, ChecksumEncoding: EncodingSRI}},
+	} {
+		t.Run(tc.file, func(t *testing.T) {
+			got, err := EncodeEntries(tc.format, goldenEntries(), tc.opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+			golden := filepath.Join("testdata", tc.file)
+			if *update {
+				if err := os.WriteFile(golden, got, 0644); err != nil {
+					t.Fatal(err)
+				}
+			}
+			want, err := os.ReadFile(golden)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(got, want) {
+				t.Errorf("%s differs from the golden file:\n%s", tc.file, got)
+			}
+			// and it must read back to the same entries
+			back, err := ParseEntries(want, tc.format)
+			if err != nil {
+				t.Fatal(err)
+			}
+			again, err := EncodeEntries(tc.format, back, tc.opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(again, want) {
+				t.Errorf("%s changes when read and written again:\n%s", tc.file, again)
+			}
+		})
+	}
+}
+
+// TestHumanDateOnlyWhenPresent releases into a manifest written without
+// date-rfc3339 and one that has it: the old entries keep their bytes and
+// the new one follows the manifest.
+func TestHumanDateOnlyWhenPresent(t *testing.T) {
+	for _, tc := range []struct {
+		name, manifest string
+		want           bool
+	}{
+		{"plain", `[{"version":"1.0.0","utc-unixnano":1700000000000000000,"links":[]}]`, false},
+		{"migrated", `[{"version":"1.0.0","utc-unixnano":1700000000000000000,"links":[],"date-rfc3339":"2023-11-14T22:13:20Z"}]`, true},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			entries, err := ParseEntries([]byte(tc.manifest), FormatJSON)
+			if err != nil {
+				t.Fatal(err)
+			}
+			entries = UpsertEntry(entries, Entry{Version: "1.1.0", Date: 1710000000000000000, Links: []DownloadInfo{}})
+			out, err := EncodeEntries(FormatJSONL, entries, ManifestOptions{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			lines := bytes.Split(bytes.TrimSpace(out), []byte("\n"))
+			if string(lines[0]) != tc.manifest[1:len(tc.manifest)-1] {
+				t.Errorf("old entry rewritten:\n got %s\nwant %s", lines[0], tc.manifest[1:len(tc.manifest)-1])
+			}
+			if got := bytes.Contains(lines[1], []byte(`"date-rfc3339"`)); got != tc.want {
+				t.Errorf("new entry has date-rfc3339 = %v, want %v: %s", got, tc.want, lines[1])
+			}
+		})
+	}
+}
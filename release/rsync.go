@@ -0,0 +1,49 @@
+package release
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+)
+
+// RsyncTransport is SSHTransport with uploads done by rsync, which only
+// sends what changed and can resume interrupted transfers. Links are still
+// made with ln -sfn over ssh.
+type RsyncTransport struct {
+	*SSHTransport
+
+	// RsyncCommand names the rsync binary; empty means "rsync".
+	RsyncCommand string
+}
+
+var _ Transport = (*RsyncTransport)(nil)
+
+func (t *RsyncTransport) rsyncBin() string {
+	if t.RsyncCommand != "" {
+		return t.RsyncCommand
+	}
+	return "rsync"
+}
+
+// Upload sends all locals to dir in a single rsync run.
+func (t *RsyncTransport) Upload(ctx context.Context, dir string, locals ...string) error {
+	cmd := exec.CommandContext(ctx, t.rsyncBin(), t.uploadArgs(dir, locals)...)
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = t.stdout()
+	cmd.Stderr = t.stderr()
+	if err := RunCommand(ctx, cmd); err != nil {
+		return fmt.Errorf("rsync to %s failed: %w", dir, err)
+	}
+	return nil
+}
+
+// uploadArgs returns the rsync arguments that send locals to dir.
+func (t *RsyncTransport) uploadArgs(dir string, locals []string) []string {
+	// rsync splits -e on spaces, which is fine for the options we add
+	rsh := strings.Join(append([]string{t.sshBin()}, t.sshArgs()...), " ")
+	args := []string{"-az", "--partial", "--links", "-e", rsh}
+	args = append(args, locals...)
+	return append(args, fmt.Sprintf("%s:%s/", t.login(), strings.TrimRight(dir, "/")))
+}
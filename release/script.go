@@ -0,0 +1,138 @@
+package release
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// ScriptTransport writes the ssh/scp commands SSHTransport would run to W
+// as a shell script instead of running them.
+type ScriptTransport struct {
+	*SSHTransport
+	W io.Writer
+
+	// Uploader, if it is an RsyncTransport or SFTPTransport, records
+	// uploads as that transport would run them instead of with scp.
+	Uploader Transport
+	// TmpDir records uploads staged through it, as StagedTransport does.
+	TmpDir string
+}
+
+var _ Transport = (*ScriptTransport)(nil)
+
+// WriteScriptHeader starts a script for ScriptTransport.
+func WriteScriptHeader(w io.Writer) error {
+	_, err := io.WriteString(w, "#!/bin/sh\n# commands recorded by relayUpdater -dry-run\nset -e\n\n")
+	return err
+}
+
+func (t *ScriptTransport) line(argv ...string) error {
+	quoted := make([]string, len(argv))
+	for i, a := range argv {
+		quoted[i] = ShellQuote(a)
+	}
+	_, err := fmt.Fprintln(t.W, strings.Join(quoted, " "))
+	return err
+}
+
+// heredoc records argv reading lines from its standard input.
+func (t *ScriptTransport) heredoc(argv []string, lines ...string) error {
+	quoted := make([]string, len(argv))
+	for i, a := range argv {
+		quoted[i] = ShellQuote(a)
+	}
+	_, err := fmt.Fprintf(t.W, "%s <<'EOF'\n%s\nEOF\n", strings.Join(quoted, " "), strings.Join(lines, "\n"))
+	return err
+}
+
+func (t *ScriptTransport) ssh(remoteCmd string) error {
+	return t.line(append(append([]string{t.sshBin()}, t.sshArgs()...), t.login(), remoteCmd)...)
+}
+
+func (t *ScriptTransport) MkdirAll(ctx context.Context, dir string) error {
+	if err := t.ssh("mkdir -p " + ShellQuote(dir)); err != nil {
+		return err
+	}
+	return t.ssh("test -d " + ShellQuote(dir))
+}
+
+func (t *ScriptTransport) Upload(ctx context.Context, dir string, locals ...string) error {
+	if t.TmpDir == "" {
+		return t.upload(dir, locals)
+	}
+	// the script runs one upload at a time, so one staging directory
+	// will do; the mv is only atomic if it is on the filesystem of dir
+	stage := path.Join(t.TmpDir, ".relay-upload.script")
+	if err := t.ssh(fmt.Sprintf(`test "$(%s)" = "$(%s)"`, statDev(t.TmpDir), statDev(dir))); err != nil {
+		return err
+	}
+	if err := t.ssh("rm -rf " + ShellQuote(stage) + " && mkdir " + ShellQuote(stage)); err != nil {
+		return err
+	}
+	if err := t.upload(stage, locals); err != nil {
+		return err
+	}
+	if err := t.ssh(moveCmd(stage, dir, locals)); err != nil {
+		return err
+	}
+	return t.ssh("rm -rf " + ShellQuote(stage))
+}
+
+// upload records the commands that send locals to dir.
+func (t *ScriptTransport) upload(dir string, locals []string) error {
+	switch u := t.Uploader.(type) {
+	case *RsyncTransport:
+		return t.line(append([]string{u.rsyncBin()}, u.uploadArgs(dir, locals)...)...)
+	case *SFTPTransport:
+		// the batch goes in a here-document; a failed rename stops the
+		// script instead of falling back to rm and rename
+		for _, local := range locals {
+			dst := path.Join(dir, filepath.Base(local))
+			tmp := path.Join(dir, "."+filepath.Base(local)+".tmp")
+			if err := t.heredoc(append([]string{u.sftpBin()}, u.batchArgs()...),
+				"-rm "+sftpQuote(tmp), "put "+sftpQuote(local)+" "+sftpQuote(tmp), "rename "+sftpQuote(tmp)+" "+sftpQuote(dst)); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	for _, local := range locals {
+		argv := append(append([]string{t.scpBin()}, t.scpArgs()...), local, fmt.Sprintf("%s:%s", t.login(), dir))
+		if err := t.line(argv...); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (t *ScriptTransport) Symlink(ctx context.Context, target, link string) error {
+	return t.ssh("ln -sfn " + ShellQuote(SymlinkTarget(target, link, t.RelativeSymlinks)) + " " + ShellQuote(link))
+}
+
+func (t *ScriptTransport) Run(ctx context.Context, cmd string) error {
+	return t.ssh(cmd)
+}
+
+// Output records cmd and returns no output.
+func (t *ScriptTransport) Output(ctx context.Context, cmd string) ([]byte, error) {
+	return nil, t.ssh(cmd)
+}
+
+// Stream records cmd and writes nothing to w.
+func (t *ScriptTransport) Stream(ctx context.Context, cmd string, w io.Writer) error {
+	return t.ssh(cmd)
+}
+
+// UploadFrom cannot be recorded: the data would have to be in the script.
+func (t *ScriptTransport) UploadFrom(ctx context.Context, r io.Reader, remotePath string) error {
+	return fmt.Errorf("streaming to %s cannot be recorded in a dry-run script", remotePath)
+}
+
+// statDev prints the device number of p with GNU or BSD stat.
+func statDev(p string) string {
+	return "stat -c %d " + ShellQuote(p) + " 2>/dev/null || stat -f %d " + ShellQuote(p)
+}
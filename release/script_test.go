@@ -0,0 +1,69 @@
+package release
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// TestScriptTransportUploaders records an upload with each uploader, with
+// and without staging, then runs the script against the fakes.
+func TestScriptTransportUploaders(t *testing.T) {
+	for _, uploader := range []string{"scp", "sftp"} {
+		for _, staged := range []bool{false, true} {
+			t.Run(fmt.Sprintf("%s/staged=%v", uploader, staged), func(t *testing.T) {
+				sftp, dir, local := fakeSFTPTransport(t)
+				st := &ScriptTransport{SSHTransport: sftp.SSHTransport}
+				if uploader == "sftp" {
+					st.Uploader = sftp
+				}
+				if staged {
+					st.TmpDir = filepath.Join(filepath.Dir(dir), "tmp")
+					if err := os.Mkdir(st.TmpDir, 0755); err != nil {
+						t.Fatal(err)
+					}
+				}
+				var script bytes.Buffer
+				st.W = &script
+				if err := WriteScriptHeader(&script); err != nil {
+					t.Fatal(err)
+				}
+				if err := st.Upload(context.Background(), dir, local); err != nil {
+					t.Fatal(err)
+				}
+				if got := strings.Contains(script.String(), filepath.Base(sftp.sftpBin())); got != (uploader == "sftp") {
+					t.Errorf("script runs sftp = %v:\n%s", got, script.String())
+				}
+				if out, err := exec.Command("sh", "-c", script.String()).CombinedOutput(); err != nil {
+					t.Fatalf("script failed: %v\n%s\n%s", err, out, script.String())
+				}
+				if b, err := os.ReadFile(filepath.Join(dir, "client.zip")); err != nil || string(b) != "new" {
+					t.Errorf("client.zip = %q, %v; want the upload", b, err)
+				}
+				if staged {
+					if left, _ := os.ReadDir(st.TmpDir); len(left) != 0 {
+						t.Errorf("staging directory left behind: %v", left)
+					}
+				}
+			})
+		}
+	}
+}
+
+func TestScriptTransportRsync(t *testing.T) {
+	ssh, _ := fakeTransport(t)
+	var script bytes.Buffer
+	st := &ScriptTransport{SSHTransport: ssh, W: &script, Uploader: &RsyncTransport{SSHTransport: ssh}}
+	if err := st.Upload(context.Background(), "/srv/www/downloads/1.2.3", "client-1.2.3.zip"); err != nil {
+		t.Fatal(err)
+	}
+	want := "rsync -az --partial --links -e "
+	if got := script.String(); !strings.HasPrefix(got, want) || !strings.HasSuffix(got, " client-1.2.3.zip deploy@example.com:/srv/www/downloads/1.2.3/\n") {
+		t.Errorf("recorded %q, want an rsync of client-1.2.3.zip", got)
+	}
+}
@@ -0,0 +1,84 @@
+package release
+
+import (
+	"context"
+	"fmt"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// SFTPTransport is SSHTransport with uploads done by the sftp client. Each
+// file is written to a temporary name next to its target and renamed over
+// it, so clients never read a half-written manifest. Links are still made
+// with ln -sfn over ssh.
+type SFTPTransport struct {
+	*SSHTransport
+
+	// SFTPCommand names the sftp binary; empty means "sftp".
+	SFTPCommand string
+}
+
+var _ Transport = (*SFTPTransport)(nil)
+
+func (t *SFTPTransport) sftpBin() string {
+	if t.SFTPCommand != "" {
+		return t.SFTPCommand
+	}
+	return "sftp"
+}
+
+// Upload puts each local under a temporary name in dir and renames it into
+// place. OpenSSH's sftp renames with posix-rename where the server offers
+// it, which replaces the target atomically. Older servers refuse to rename
+// over an existing file; for them the target is removed first, which
+// leaves a short window without it. A put that fails leaves the target
+// alone.
+func (t *SFTPTransport) Upload(ctx context.Context, dir string, locals ...string) error {
+	for _, local := range locals {
+		dst := path.Join(dir, filepath.Base(local))
+		tmp := path.Join(dir, "."+filepath.Base(local)+".tmp")
+		// a tmp left by an earlier failed run must not be renamed into place
+		if err := t.batch(ctx, "-rm "+sftpQuote(tmp), "put "+sftpQuote(local)+" "+sftpQuote(tmp)); err != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			t.batch(ctx, "-rm "+sftpQuote(tmp))
+			return fmt.Errorf("sftp %s failed: %w", local, err)
+		}
+		err := t.batch(ctx, "rename "+sftpQuote(tmp)+" "+sftpQuote(dst))
+		if err == nil {
+			continue
+		}
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		// the new file did arrive, so the target can go
+		if fallback := t.batch(ctx, "-rm "+sftpQuote(dst), "rename "+sftpQuote(tmp)+" "+sftpQuote(dst)); fallback != nil {
+			return fmt.Errorf("sftp %s failed: %w", local, err)
+		}
+	}
+	return nil
+}
+
+// batch runs commands in one sftp session, stopping at the first failing
+// command that is not prefixed with "-".
+func (t *SFTPTransport) batch(ctx context.Context, commands ...string) error {
+	cmd := exec.CommandContext(ctx, t.sftpBin(), t.batchArgs()...)
+	cmd.Stdin = strings.NewReader(strings.Join(commands, "\n") + "\n")
+	cmd.Stdout = t.stdout()
+	cmd.Stderr = t.stderr()
+	return RunCommand(ctx, cmd)
+}
+
+// batchArgs returns the sftp arguments that read commands from stdin.
+func (t *SFTPTransport) batchArgs() []string {
+	args := append([]string{"-b", "-"}, t.scpArgs()...)
+	return append(args, t.login())
+}
+
+// sftpQuote quotes a path for an sftp batch file.
+func sftpQuote(s string) string {
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(s) + `"`
+}
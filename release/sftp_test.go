@@ -0,0 +1,88 @@
+package release
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// fakeSFTP runs an sftp batch from stdin on the local filesystem. Setting
+// FAKE_SFTP_PUT=partial makes put write one byte and fail, and
+// FAKE_SFTP_RENAME=noclobber makes rename refuse an existing target, as
+// older servers do.
+const fakeSFTP = `#!/bin/sh
+while read -r line; do
+	eval "set -- $line"
+	cmd=$1; shift; ign=
+	case $cmd in -*) ign=1; cmd=${cmd#-} ;; esac
+	case $cmd in
+	put)
+		if [ "$FAKE_SFTP_PUT" = partial ]; then head -c 1 "$1" > "$2"; false; else cp "$1" "$2"; fi ;;
+	rename)
+		if [ "$FAKE_SFTP_RENAME" = noclobber ] && [ -e "$2" ]; then false; else mv "$1" "$2"; fi ;;
+	rm) rm "$1" 2>/dev/null ;;
+	ls) [ -e "$1" ] ;;
+	*) false ;;
+	esac
+	if [ $? -ne 0 ] && [ -z "$ign" ]; then exit 1; fi
+done
+`
+
+// fakeSFTPTransport returns an SFTPTransport running fakeSFTP, a remote
+// root holding downloads/client.zip with content "old", and a local
+// client.zip with content "new".
+func fakeSFTPTransport(t *testing.T) (tr *SFTPTransport, dir, local string) {
+	t.Helper()
+	ssh, root := fakeTransport(t)
+	bin := filepath.Join(t.TempDir(), "sftp")
+	if err := os.WriteFile(bin, []byte(fakeSFTP), 0755); err != nil {
+		t.Fatal(err)
+	}
+	dir = filepath.Join(root, "downloads")
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "client.zip"), []byte("old"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	local = filepath.Join(t.TempDir(), "client.zip")
+	if err := os.WriteFile(local, []byte("new"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return &SFTPTransport{SSHTransport: ssh, SFTPCommand: bin}, dir, local
+}
+
+func TestSFTPTransportUpload(t *testing.T) {
+	for _, rename := range []string{"", "noclobber"} {
+		t.Run("rename="+rename, func(t *testing.T) {
+			t.Setenv("FAKE_SFTP_RENAME", rename)
+			tr, dir, local := fakeSFTPTransport(t)
+			// left behind by an earlier failed run
+			if err := os.WriteFile(filepath.Join(dir, ".client.zip.tmp"), []byte("stale"), 0644); err != nil {
+				t.Fatal(err)
+			}
+			if err := tr.Upload(context.Background(), dir, local); err != nil {
+				t.Fatal(err)
+			}
+			if b, err := os.ReadFile(filepath.Join(dir, "client.zip")); err != nil || string(b) != "new" {
+				t.Fatalf("target = %q, %v; want the new file", b, err)
+			}
+		})
+	}
+}
+
+func TestSFTPTransportUploadFailedPut(t *testing.T) {
+	t.Setenv("FAKE_SFTP_PUT", "partial")
+	t.Setenv("FAKE_SFTP_RENAME", "noclobber")
+	tr, dir, local := fakeSFTPTransport(t)
+	if err := tr.Upload(context.Background(), dir, local); err == nil {
+		t.Fatal("Upload succeeded with a failing put")
+	}
+	if b, err := os.ReadFile(filepath.Join(dir, "client.zip")); err != nil || string(b) != "old" {
+		t.Fatalf("target = %q, %v; want it untouched", b, err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, ".client.zip.tmp")); !os.IsNotExist(err) {
+		t.Errorf("partial tmp left behind: %v", err)
+	}
+}
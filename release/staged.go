@@ -0,0 +1,102 @@
+package release
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// StagedTransport uploads into a fresh directory under TmpDir first and
+// then moves the files into place with mv, so a target is never seen half
+// written. TmpDir must be on the same filesystem as every target for mv
+// to be an atomic rename; Upload checks that before moving anything.
+type StagedTransport struct {
+	Transport
+	TmpDir string
+
+	mu     sync.Mutex
+	sameFS map[string]bool
+}
+
+var _ Transport = (*StagedTransport)(nil)
+
+func (t *StagedTransport) Upload(ctx context.Context, dir string, locals ...string) error {
+	if err := t.checkSameFS(ctx, dir); err != nil {
+		return err
+	}
+	// a directory of its own, so parallel uploads cannot collide
+	out, err := t.Output(ctx, "mktemp -d "+ShellQuote(path.Join(t.TmpDir, ".relay-upload.XXXXXX")))
+	stage := strings.TrimSpace(string(out))
+	if err != nil || stage == "" {
+		return fmt.Errorf("creating a staging directory in %s failed: %v", t.TmpDir, err)
+	}
+	defer t.Run(context.WithoutCancel(ctx), "rm -rf "+ShellQuote(stage))
+
+	if err := t.Transport.Upload(ctx, stage, locals...); err != nil {
+		return err
+	}
+	if err := t.Run(ctx, moveCmd(stage, dir, locals)); err != nil {
+		return fmt.Errorf("moving uploads from %s to %s failed: %w", stage, dir, err)
+	}
+	return nil
+}
+
+// moveCmd moves the uploads of locals from stage into dir.
+func moveCmd(stage, dir string, locals []string) string {
+	mvs := make([]string, len(locals))
+	for i, l := range locals {
+		name := filepath.Base(l)
+		mvs[i] = "mv -f " + ShellQuote(path.Join(stage, name)) + " " + ShellQuote(path.Join(dir, name))
+	}
+	return strings.Join(mvs, " && ")
+}
+
+// checkSameFS makes sure TmpDir and dir are on one filesystem, comparing
+// their device numbers with GNU or BSD stat.
+func (t *StagedTransport) checkSameFS(ctx context.Context, dir string) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.sameFS[dir] {
+		return nil
+	}
+	a, b := ShellQuote(t.TmpDir), ShellQuote(dir)
+	out, err := t.Output(ctx, fmt.Sprintf("stat -c %%d %s %s 2>/dev/null || stat -f %%d %s %s", a, b, a, b))
+	if err != nil {
+		return fmt.Errorf("checking the filesystems of %s and %s failed: %w", t.TmpDir, dir, err)
+	}
+	devs := strings.Fields(string(out))
+	if len(devs) != 2 {
+		return fmt.Errorf("checking the filesystems of %s and %s: unexpected stat output %q", t.TmpDir, dir, out)
+	}
+	if devs[0] != devs[1] {
+		return fmt.Errorf("%s and %s are on different filesystems, so moving uploads into place would not be atomic", t.TmpDir, dir)
+	}
+	if t.sameFS == nil {
+		t.sameFS = map[string]bool{}
+	}
+	t.sameFS[dir] = true
+	return nil
+}
+
+// UploadFrom passes through to the wrapped transport, which writes a
+// temporary file next to remotePath itself.
+func (t *StagedTransport) UploadFrom(ctx context.Context, r io.Reader, remotePath string) error {
+	u, ok := t.Transport.(StreamUploader)
+	if !ok {
+		return fmt.Errorf("this transport cannot stream uploads")
+	}
+	return u.UploadFrom(ctx, r, remotePath)
+}
+
+// Stream passes through to the wrapped transport, if it can stream.
+func (t *StagedTransport) Stream(ctx context.Context, cmd string, w io.Writer) error {
+	s, ok := t.Transport.(Streamer)
+	if !ok {
+		return fmt.Errorf("this transport cannot stream files")
+	}
+	return s.Stream(ctx, cmd, w)
+}
@@ -0,0 +1,389 @@
+package release
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// Transport carries out the remote side of a release. Paths are remote
+// paths; commands are run by a POSIX shell on the remote host.
+type Transport interface {
+	// MkdirAll creates dir and its parents and confirms that it exists.
+	MkdirAll(ctx context.Context, dir string) error
+	// Upload copies local files into the remote directory dir.
+	Upload(ctx context.Context, dir string, locals ...string) error
+	// Symlink creates or replaces link so that it points at target.
+	Symlink(ctx context.Context, target, link string) error
+	// Run executes cmd with its output going to the local terminal.
+	Run(ctx context.Context, cmd string) error
+	// Output executes cmd and returns its standard output.
+	Output(ctx context.Context, cmd string) ([]byte, error)
+}
+
+// Streamer is implemented by transports that can hand the output of a
+// remote command to w as it arrives instead of holding all of it.
+type Streamer interface {
+	Stream(ctx context.Context, cmd string, w io.Writer) error
+}
+
+// StreamUploader is implemented by transports that can write a remote
+// file straight from a reader, with no local file to upload.
+type StreamUploader interface {
+	UploadFrom(ctx context.Context, r io.Reader, remotePath string) error
+}
+
+// SSHTransport publishes over ssh, uploading with scp.
+type SSHTransport struct {
+	Host string
+	Port string // empty for the ssh default
+	User string
+	Jump string // [user@]host[:port] of an optional bastion
+
+	// SSHCommand and SCPCommand name the binaries to run; empty means
+	// "ssh" and "scp".
+	SSHCommand string
+	SCPCommand string
+
+	// Options are ssh_config settings ("Key=value") passed with -o to
+	// every ssh, scp and sftp call, e.g. StrictHostKeyChecking=accept-new.
+	Options []string
+
+	// Stdout and Stderr receive the output of ssh and scp; nil means the
+	// process's own.
+	Stdout io.Writer
+	Stderr io.Writer
+
+	// RelativeSymlinks makes Symlink store targets relative to the link;
+	// see SymlinkTarget.
+	RelativeSymlinks bool
+}
+
+var _ Transport = (*SSHTransport)(nil)
+
+// NewSSHTransport validates host[:port] and the optional jump host.
+func NewSSHTransport(hostPort, user, jump string) (*SSHTransport, error) {
+	hostUser, host, port, err := ParseLogin(hostPort)
+	if err != nil {
+		return nil, err
+	}
+	if hostUser != "" {
+		user = hostUser
+	}
+	if jump != "" {
+		if jump, err = ParseJumpHost(jump); err != nil {
+			return nil, fmt.Errorf("jump host: %w", err)
+		}
+	}
+	return &SSHTransport{Host: host, Port: port, User: user, Jump: jump}, nil
+}
+
+// login returns user@host, or just host when the user is left to the ssh
+// config.
+func (t *SSHTransport) login() string {
+	if t.User == "" {
+		return t.Host
+	}
+	return fmt.Sprintf("%s@%s", t.User, t.Host)
+}
+
+// ResolveSSHAlias asks ssh (sshCommand, or "ssh" if empty) how it would
+// connect to alias, using "ssh -G", and returns the real host name and port
+// from the user's ssh config.
+func ResolveSSHAlias(ctx context.Context, sshCommand, alias string) (host, port string, err error) {
+	if sshCommand == "" {
+		sshCommand = "ssh"
+	}
+	out, err := exec.CommandContext(ctx, sshCommand, "-G", alias).Output()
+	if err != nil {
+		return "", "", fmt.Errorf("ssh -G %s: %w", alias, err)
+	}
+	for _, line := range strings.Split(string(out), "\n") {
+		key, val, _ := strings.Cut(strings.TrimSpace(line), " ")
+		switch strings.ToLower(key) {
+		case "hostname":
+			host = val
+		case "port":
+			port = val
+		}
+	}
+	if host == "" {
+		return "", "", fmt.Errorf("ssh -G %s printed no hostname", alias)
+	}
+	return host, port, nil
+}
+
+func (t *SSHTransport) stdout() io.Writer {
+	if t.Stdout != nil {
+		return t.Stdout
+	}
+	return os.Stdout
+}
+
+func (t *SSHTransport) stderr() io.Writer {
+	if t.Stderr != nil {
+		return t.Stderr
+	}
+	return os.Stderr
+}
+
+func (t *SSHTransport) sshBin() string {
+	if t.SSHCommand != "" {
+		return t.SSHCommand
+	}
+	return "ssh"
+}
+
+func (t *SSHTransport) scpBin() string {
+	if t.SCPCommand != "" {
+		return t.SCPCommand
+	}
+	return "scp"
+}
+
+// sshArgs returns the connection options shared by every ssh call.
+func (t *SSHTransport) sshArgs() []string {
+	args := []string{}
+	if t.Port != "" {
+		args = append(args, "-p", t.Port)
+	}
+	if t.Jump != "" {
+		args = append(args, "-J", t.Jump)
+	}
+	for _, o := range t.Options {
+		args = append(args, "-o", o)
+	}
+	return args
+}
+
+// scpArgs is sshArgs for scp, which spells the port flag differently and
+// has no -J on older releases.
+func (t *SSHTransport) scpArgs() []string {
+	args := []string{}
+	if t.Port != "" {
+		args = append(args, "-P", t.Port)
+	}
+	if t.Jump != "" {
+		args = append(args, "-o", "ProxyJump="+t.Jump)
+	}
+	for _, o := range t.Options {
+		args = append(args, "-o", o)
+	}
+	return args
+}
+
+// Command builds an ssh invocation that runs remoteCmd on the remote
+// host, wired to the local terminal.
+func (t *SSHTransport) Command(ctx context.Context, remoteCmd string) *exec.Cmd {
+	args := append(t.sshArgs(), t.login(), remoteCmd)
+	cmd := exec.CommandContext(ctx, t.sshBin(), args...)
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = t.stdout()
+	cmd.Stderr = t.stderr()
+	return cmd
+}
+
+func (t *SSHTransport) Run(ctx context.Context, remoteCmd string) error {
+	return RunCommand(ctx, t.Command(ctx, remoteCmd))
+}
+
+func (t *SSHTransport) Output(ctx context.Context, remoteCmd string) ([]byte, error) {
+	cmd := t.Command(ctx, remoteCmd)
+	var out bytes.Buffer
+	cmd.Stdout = &out
+	err := RunCommand(ctx, cmd)
+	return out.Bytes(), err
+}
+
+// ShellQuote quotes s for a POSIX shell using single quotes.
+func ShellQuote(s string) string {
+	if s != "" && strings.IndexFunc(s, func(r rune) bool {
+		return !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || strings.ContainsRune("-_./:@=,+", r))
+	}) < 0 {
+		return s
+	}
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
+
+// Stream executes cmd and copies its standard output to w.
+func (t *SSHTransport) Stream(ctx context.Context, remoteCmd string, w io.Writer) error {
+	cmd := t.Command(ctx, remoteCmd)
+	cmd.Stdout = w
+	return RunCommand(ctx, cmd)
+}
+
+// UploadFrom pipes r into cat on the server, writing a temporary file
+// next to remotePath that is renamed over it once complete.
+func (t *SSHTransport) UploadFrom(ctx context.Context, r io.Reader, remotePath string) error {
+	tmp := ShellQuote(path.Join(path.Dir(remotePath), "."+path.Base(remotePath)+".tmp"))
+	cmd := t.Command(ctx, fmt.Sprintf("cat > %s && mv -f %s %s", tmp, tmp, ShellQuote(remotePath)))
+	cmd.Stdin = r
+	if err := RunCommand(ctx, cmd); err != nil {
+		return fmt.Errorf("streaming to %s failed: %w", remotePath, err)
+	}
+	return nil
+}
+
+// Ping runs "true" on the host to prove it can be reached and logged into.
+// Its error says whether the name did not resolve, the connection failed
+// or authentication was refused, with ssh's own message attached.
+func (t *SSHTransport) Ping(ctx context.Context) error {
+	args := append(t.sshArgs(), "-o", "ConnectTimeout=15", t.login(), "true")
+	cmd := exec.CommandContext(ctx, t.sshBin(), args...)
+	cmd.Stdin = os.Stdin
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	err := RunCommand(ctx, cmd)
+	if err == nil || ctx.Err() != nil {
+		return err
+	}
+	msg := strings.TrimSpace(stderr.String())
+	kind := "ssh failed"
+	switch {
+	case containsAny(msg, "Could not resolve hostname", "Name or service not known", "nodename nor servname"):
+		kind = "DNS lookup failed"
+	case containsAny(msg, "Connection refused", "Connection timed out", "No route to host", "Network is unreachable", "Connection reset", "Connection closed"):
+		kind = "connection failed"
+	case containsAny(msg, "Permission denied", "Host key verification failed", "Too many authentication failures"):
+		kind = "authentication failed"
+	}
+	if msg == "" {
+		msg = err.Error()
+	}
+	return fmt.Errorf("cannot reach %s: %s: %s", t.login(), kind, msg)
+}
+
+func containsAny(s string, subs ...string) bool {
+	for _, sub := range subs {
+		if strings.Contains(s, sub) {
+			return true
+		}
+	}
+	return false
+}
+
+func (t *SSHTransport) MkdirAll(ctx context.Context, dir string) error {
+	if err := t.Run(ctx, "mkdir -p "+ShellQuote(dir)); err != nil {
+		return err
+	}
+
+	// some shells swallow mkdir -p failures (e.g. permission denied),
+	// so confirm the directory really exists before going on
+	if err := t.Run(ctx, "test -d "+ShellQuote(dir)); err != nil {
+		return fmt.Errorf("remote directory %s does not exist after mkdir: %w", dir, err)
+	}
+	return nil
+}
+
+func (t *SSHTransport) Upload(ctx context.Context, dir string, locals ...string) error {
+	for _, local := range locals {
+		args := append(t.scpArgs(), local, fmt.Sprintf("%s:%s", t.login(), dir))
+		cmd := exec.CommandContext(ctx, t.scpBin(), args...)
+		cmd.Stdin = os.Stdin
+		cmd.Stdout = t.stdout()
+		cmd.Stderr = t.stderr()
+		if err := RunCommand(ctx, cmd); err != nil {
+			return fmt.Errorf("scp %s failed: %w", local, err)
+		}
+	}
+	return nil
+}
+
+func (t *SSHTransport) Symlink(ctx context.Context, target, link string) error {
+	// ssh [-p port] user@host "ln -sfn <target> <link>"
+	return t.Run(ctx, "ln -sfn "+ShellQuote(SymlinkTarget(target, link, t.RelativeSymlinks))+" "+ShellQuote(link))
+}
+
+// SymlinkTarget returns what Symlink stores in link to point it at target.
+// With relative set the target is made relative to the link's directory,
+// e.g. "0.2.5/client-0.2.5.zip", so the link keeps working when the tree
+// is moved; otherwise it is stored as given.
+func SymlinkTarget(target, link string, relative bool) string {
+	if !relative {
+		return target
+	}
+	rel, err := filepath.Rel(filepath.Dir(link), target)
+	if err != nil {
+		return target
+	}
+	return filepath.ToSlash(rel)
+}
+
+// RunCommand runs cmd and, if ctx ended while it ran, reports ctx's error
+// instead of the "signal: killed" left behind by exec.CommandContext.
+func RunCommand(ctx context.Context, cmd *exec.Cmd) error {
+	err := cmd.Run()
+	if err != nil && ctx.Err() != nil {
+		return ctx.Err()
+	}
+	return err
+}
+
+// ParseHostPort splits host[:port], accepting bracketed IPv6 literals, and
+// checks that the port is a number in range.
+func ParseHostPort(hp string) (host, port string, err error) {
+	if hp == "" {
+		return "", "", errors.New("empty host")
+	}
+	if !strings.Contains(hp, ":") || (strings.Count(hp, ":") > 1 && !strings.HasPrefix(hp, "[")) {
+		// plain host name or bare IPv6 address
+		return hp, "", nil
+	}
+	host, port, err = net.SplitHostPort(hp)
+	if err != nil {
+		return "", "", err
+	}
+	if host == "" {
+		return "", "", fmt.Errorf("missing host in %q", hp)
+	}
+	if n, err := strconv.Atoi(port); err != nil || n < 1 || n > 65535 {
+		return "", "", fmt.Errorf("invalid port %q in %q", port, hp)
+	}
+	return host, port, nil
+}
+
+// ParseLogin splits a [user@]host[:port] spec; user and port are "" when
+// not given.
+func ParseLogin(spec string) (user, host, port string, err error) {
+	hp := spec
+	if i := strings.LastIndex(spec, "@"); i >= 0 {
+		user, hp = spec[:i], spec[i+1:]
+		if user == "" {
+			return "", "", "", fmt.Errorf("empty user in %q", spec)
+		}
+	}
+	host, port, err = ParseHostPort(hp)
+	if err != nil {
+		return "", "", "", err
+	}
+	return user, host, port, nil
+}
+
+// ParseJumpHost validates a [user@]host[:port] bastion spec and returns it
+// in the form ssh -J expects.
+func ParseJumpHost(spec string) (string, error) {
+	user, host, port, err := ParseLogin(spec)
+	if err != nil {
+		return "", err
+	}
+	out := host
+	if strings.Contains(host, ":") {
+		out = "[" + host + "]"
+	}
+	if port != "" {
+		out += ":" + port
+	}
+	if user != "" {
+		out = user + "@" + out
+	}
+	return out, nil
+}
@@ -0,0 +1,221 @@
+package release
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// fakeSSH runs the remote command locally with sh, so a temp dir stands in
+// for the server.
+const fakeSSH = `#!/bin/sh
+for a; do last=$a; done
+exec sh -c "$last"
+`
+
+// fakeSCP copies its next-to-last argument into the directory after the
+// "host:" of its last, which may be a bracketed IPv6 address.
+const fakeSCP = `#!/bin/sh
+for a; do src=$last; last=$a; done
+case $last in
+\[*) dir=${last#*]:} ;;
+*) dir=${last#*:} ;;
+esac
+exec cp "$src" "$dir"
+`
+
+// fakeTransport returns an SSHTransport whose ssh and scp are the fakes
+// above, plus a directory to use as the remote root.
+func fakeTransport(t *testing.T) (*SSHTransport, string) {
+	t.Helper()
+	bin := t.TempDir()
+	write := func(name, script string) string {
+		p := filepath.Join(bin, name)
+		if err := os.WriteFile(p, []byte(script), 0755); err != nil {
+			t.Fatal(err)
+		}
+		return p
+	}
+	tr := &SSHTransport{
+		Host:       "example.com",
+		User:       "deploy",
+		SSHCommand: write("ssh", fakeSSH),
+		SCPCommand: write("scp", fakeSCP),
+	}
+	return tr, t.TempDir()
+}
+
+func TestSSHTransportMkdirAll(t *testing.T) {
+	tr, root := fakeTransport(t)
+	dir := filepath.Join(root, "downloads", "1.2.3")
+	if err := tr.MkdirAll(context.Background(), dir); err != nil {
+		t.Fatal(err)
+	}
+	if fi, err := os.Stat(dir); err != nil || !fi.IsDir() {
+		t.Fatalf("%s was not created: %v", dir, err)
+	}
+	// again, as a retry or a second mirror would
+	if err := tr.MkdirAll(context.Background(), dir); err != nil {
+		t.Fatalf("second MkdirAll: %v", err)
+	}
+}
+
+func TestSSHTransportMkdirAllSwallowedFailure(t *testing.T) {
+	tr, root := fakeTransport(t)
+	// a shell that reports success for mkdir without creating anything
+	swallow := filepath.Join(t.TempDir(), "ssh")
+	script := "#!/bin/sh\nfor a; do last=$a; done\ncase $last in mkdir*) exit 0 ;; esac\nexec sh -c \"$last\"\n"
+	if err := os.WriteFile(swallow, []byte(script), 0755); err != nil {
+		t.Fatal(err)
+	}
+	tr.SSHCommand = swallow
+	err := tr.MkdirAll(context.Background(), filepath.Join(root, "missing"))
+	if err == nil || !strings.Contains(err.Error(), "does not exist after mkdir") {
+		t.Fatalf("MkdirAll = %v, want the directory check to fail", err)
+	}
+}
+
+func TestSSHTransportUploadAndSymlink(t *testing.T) {
+	ctx := context.Background()
+	tr, root := fakeTransport(t)
+	local := filepath.Join(t.TempDir(), "client-1.2.3.zip")
+	if err := os.WriteFile(local, []byte("zip"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	versionDir := filepath.Join(root, "downloads", "1.2.3")
+	if err := tr.MkdirAll(ctx, versionDir); err != nil {
+		t.Fatal(err)
+	}
+	if err := tr.Upload(ctx, versionDir, local); err != nil {
+		t.Fatal(err)
+	}
+	target := filepath.Join(versionDir, "client-1.2.3.zip")
+	if b, err := os.ReadFile(target); err != nil || string(b) != "zip" {
+		t.Fatalf("uploaded file = %q, %v", b, err)
+	}
+
+	link := filepath.Join(root, "downloads", "client-latest.zip")
+	if err := tr.Symlink(ctx, target, link); err != nil {
+		t.Fatal(err)
+	}
+	if got, err := os.Readlink(link); err != nil || got != target {
+		t.Fatalf("link points at %q (%v), want %q", got, err, target)
+	}
+	// a later release moves the link on
+	next := filepath.Join(root, "downloads", "1.2.4", "client-1.2.4.zip")
+	if err := tr.Symlink(ctx, next, link); err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := os.Readlink(link); got != next {
+		t.Fatalf("link points at %q after the second release, want %q", got, next)
+	}
+}
+
+func TestSymlinkTarget(t *testing.T) {
+	for _, c := range []struct {
+		target, link string
+		relative     bool
+		want         string
+	}{
+		{"/srv/downloads/0.2.5/client-0.2.5.zip", "/srv/downloads/client-latest.zip", false, "/srv/downloads/0.2.5/client-0.2.5.zip"},
+		{"/srv/downloads/0.2.5/client-0.2.5.zip", "/srv/downloads/client-latest.zip", true, "0.2.5/client-0.2.5.zip"},
+		// a -dedupe-storage link between two version directories
+		{"/srv/downloads/0.2.4/client.zip", "/srv/downloads/0.2.5/client.zip", true, "../0.2.4/client.zip"},
+		{"/srv/relayClient.json", "/srv/relayClient-latest.json", true, "relayClient.json"},
+	} {
+		if got := SymlinkTarget(c.target, c.link, c.relative); got != c.want {
+			t.Errorf("SymlinkTarget(%s, %s, %v) = %s, want %s", c.target, c.link, c.relative, got, c.want)
+		}
+	}
+}
+
+// TestSymlinkStyles makes a -latest link with each transport in both
+// styles, then moves the whole tree: only relative links survive that.
+func TestSymlinkStyles(t *testing.T) {
+	for _, relative := range []bool{false, true} {
+		for _, name := range []string{"ssh", "local"} {
+			t.Run(fmt.Sprintf("%s/relative=%v", name, relative), func(t *testing.T) {
+				var tr Transport
+				var root string
+				if name == "ssh" {
+					ssh, dir := fakeTransport(t)
+					ssh.RelativeSymlinks = relative
+					tr, root = ssh, dir
+				} else {
+					tr, root = &LocalTransport{RelativeSymlinks: relative}, t.TempDir()
+				}
+				base := filepath.Join(root, "www", "downloads")
+				target := filepath.Join(base, "0.2.5", "client-0.2.5.zip")
+				if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+					t.Fatal(err)
+				}
+				if err := os.WriteFile(target, []byte("zip"), 0644); err != nil {
+					t.Fatal(err)
+				}
+				link := filepath.Join(base, "client-latest.zip")
+				if err := tr.Symlink(context.Background(), target, link); err != nil {
+					t.Fatal(err)
+				}
+				want := target
+				if relative {
+					want = "0.2.5/client-0.2.5.zip"
+				}
+				if got, err := os.Readlink(link); err != nil || got != want {
+					t.Fatalf("link stores %q (%v), want %q", got, err, want)
+				}
+
+				moved := filepath.Join(root, "webroot")
+				if err := os.Rename(filepath.Join(root, "www"), moved); err != nil {
+					t.Fatal(err)
+				}
+				_, err := os.ReadFile(filepath.Join(moved, "downloads", "client-latest.zip"))
+				if relative && err != nil {
+					t.Errorf("relative link broke when the tree moved: %v", err)
+				}
+				if !relative && err == nil {
+					t.Errorf("absolute link still resolves after its target moved")
+				}
+			})
+		}
+	}
+}
+
+func TestParseLogin(t *testing.T) {
+	for _, c := range []struct {
+		spec             string
+		user, host, port string
+		err              bool
+	}{
+		{spec: "example.org", host: "example.org"},
+		{spec: "deploy@example.org", user: "deploy", host: "example.org"},
+		{spec: "example.org:2222", host: "example.org", port: "2222"},
+		{spec: "deploy@example.org:2222", user: "deploy", host: "example.org", port: "2222"},
+		{spec: "[::1]:22", host: "::1", port: "22"},
+		{spec: "deploy@[::1]:22", user: "deploy", host: "::1", port: "22"},
+		{spec: "::1", host: "::1"},
+		// only the last @ ends the user
+		{spec: "a@b@example.org", user: "a@b", host: "example.org"},
+		{spec: "@example.org", err: true},
+		{spec: "example.org:ssh", err: true},
+		{spec: "example.org:70000", err: true},
+		{spec: "", err: true},
+	} {
+		user, host, port, err := ParseLogin(c.spec)
+		if c.err {
+			if err == nil {
+				t.Errorf("ParseLogin(%q) = %q, %q, %q, want an error", c.spec, user, host, port)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("ParseLogin(%q): %v", c.spec, err)
+			continue
+		}
+		if user != c.user || host != c.host || port != c.port {
+			t.Errorf("ParseLogin(%q) = %q, %q, %q, want %q, %q, %q", c.spec, user, host, port, c.user, c.host, c.port)
+		}
+	}
+}
@@ -0,0 +1,82 @@
+package release
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+	"sort"
+	"strings"
+
+	semver "github.com/Masterminds/semver/v3"
+)
+
+// RolloutBucket places clientID in one of 100 buckets for version. The same
+// client always lands in the same bucket for a version, and buckets differ
+// between versions so the same clients are not always first.
+func RolloutBucket(clientID, version string) int {
+	sum := sha256.Sum256([]byte(clientID + "\x00" + version))
+	return int(binary.BigEndian.Uint64(sum[:8]) % 100)
+}
+
+// InRollout reports whether clientID may auto-update to e.
+func InRollout(e Entry, clientID string) bool {
+	if e.RolloutPercent == nil {
+		return true
+	}
+	return RolloutBucket(clientID, e.Version) < *e.RolloutPercent
+}
+
+// InChannel reports whether a client following channel gets l. Stable
+// links, with no channel, go to every client; the others only to clients
+// of their channel. channel "" is the stable channel.
+func InChannel(l DownloadInfo, channel string) bool {
+	return l.Channel == "" || l.Channel == channel
+}
+
+// ForChannel returns entries as a client following channel sees them: each
+// with only the links InChannel keeps, and without the entries left with
+// none, such as a release that only shipped a beta artifact.
+func ForChannel(entries []Entry, channel string) []Entry {
+	out := make([]Entry, 0, len(entries))
+	for _, e := range entries {
+		var links []DownloadInfo
+		for _, l := range e.Links {
+			if InChannel(l, channel) {
+				links = append(links, l)
+			}
+		}
+		if len(links) == 0 {
+			continue
+		}
+		e.Links = links
+		out = append(out, e)
+	}
+	return out
+}
+
+// NewerRelease returns the highest entry above current that clientID is
+// rolled out to, and false if there is none.
+func NewerRelease(entries []Entry, current, clientID string) (Entry, bool, error) {
+	cur, err := semver.NewVersion(strings.TrimSpace(current))
+	if err != nil {
+		return Entry{}, false, fmt.Errorf("invalid current version %q: %w", current, err)
+	}
+	type candidate struct {
+		v *semver.Version
+		e Entry
+	}
+	var newer []candidate
+	for _, e := range entries {
+		v, err := semver.NewVersion(strings.TrimSpace(e.Version))
+		if err == nil && v.GreaterThan(cur) {
+			newer = append(newer, candidate{v, e})
+		}
+	}
+	sort.Slice(newer, func(i, j int) bool { return newer[i].v.GreaterThan(newer[j].v) })
+	for _, c := range newer {
+		if InRollout(c.e, clientID) {
+			return c.e, true, nil
+		}
+	}
+	return Entry{}, false, nil
+}
@@ -0,0 +1,47 @@
+package release
+
+import "testing"
+
+func TestForChannel(t *testing.T) {
+	entries := []Entry{
+		{Version: "1.0.0", Links: []DownloadInfo{{Link: "a-1.0.0.zip"}, {Link: "plugin-1.0.0.zip", Channel: "beta"}}},
+		{Version: "1.1.0", Links: []DownloadInfo{{Link: "plugin-1.1.0.zip", Channel: "beta"}}},
+		{Version: "1.2.0", Links: []DownloadInfo{{Link: "plugin-1.2.0.zip", Channel: "nightly"}}},
+	}
+	for _, tc := range []struct {
+		channel string
+		want    map[string]int // version -> links kept
+	}{
+		{"", map[string]int{"1.0.0": 1}},
+		{"beta", map[string]int{"1.0.0": 2, "1.1.0": 1}},
+		{"nightly", map[string]int{"1.0.0": 1, "1.2.0": 1}},
+	} {
+		got := ForChannel(entries, tc.channel)
+		if len(got) != len(tc.want) {
+			t.Errorf("channel %q: got %d entries, want %d", tc.channel, len(got), len(tc.want))
+			continue
+		}
+		for _, e := range got {
+			if len(e.Links) != tc.want[e.Version] {
+				t.Errorf("channel %q: %s has %d links, want %d", tc.channel, e.Version, len(e.Links), tc.want[e.Version])
+			}
+		}
+	}
+	if len(entries[0].Links) != 2 {
+		t.Error("ForChannel changed the entries it was given")
+	}
+}
+
+func TestNewerReleaseByChannel(t *testing.T) {
+	entries := []Entry{
+		{Version: "1.0.0", Links: []DownloadInfo{{Link: "a-1.0.0.zip"}}},
+		{Version: "1.1.0", Links: []DownloadInfo{{Link: "a-1.1.0.zip", Channel: "beta"}}},
+	}
+	if _, ok, err := NewerRelease(ForChannel(entries, ""), "1.0.0", "c"); err != nil || ok {
+		t.Errorf("stable client: update = %v, %v; want none", ok, err)
+	}
+	e, ok, err := NewerRelease(ForChannel(entries, "beta"), "1.0.0", "c")
+	if err != nil || !ok || e.Version != "1.1.0" {
+		t.Errorf("beta client: got %s, %v, %v; want 1.1.0", e.Version, ok, err)
+	}
+}
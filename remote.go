@@ -0,0 +1,395 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+
+	"relayUpdater/release"
+)
+
+// remoteDownloads is the downloads directory under remoteDir. It and
+// remoteVersionPath are the only places remote paths get built, so that
+// uploads, links and checks agree however -remote-dir is written.
+func remoteDownloads(remoteDir string) string {
+	return path.Join(remoteDir, dlDir)
+}
+
+// remoteVersionPath is the directory of one version under remoteDir.
+func remoteVersionPath(remoteDir, version string) string {
+	return path.Join(remoteDownloads(remoteDir), version)
+}
+
+// updateLatestFileSymlinks creates/updates, for each versioned file, a
+// root‑level "-latest" symlink pointing to the versioned path. channels
+// maps file names to their release channel; see LatestLinkPathsIn.
+func updateLatestFileSymlinks(ctx context.Context, t release.Transport, remoteBase, newVersion string, files []string, channels map[string]string) error {
+	for _, f := range files {
+		target, link := release.LatestLinkPathsIn(remoteBase, newVersion, f, channels[f])
+		if err := t.Symlink(ctx, target, link); err != nil {
+			return fmt.Errorf("updating symlink for %s: %w", f, err)
+		}
+	}
+	return nil
+}
+
+// verifyLatestSymlinks reads back each "-latest" link and reports every
+// link that does not point at the expected versioned file, stored relative
+// to the link if relative is set.
+func verifyLatestSymlinks(ctx context.Context, t release.Transport, remoteBase, version string, files []string, channels map[string]string, relative bool) error {
+	var bad []string
+	for _, f := range files {
+		target, link := release.LatestLinkPathsIn(remoteBase, version, f, channels[f])
+		out, err := t.Output(ctx, "readlink "+release.ShellQuote(link))
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if err != nil {
+			bad = append(bad, fmt.Sprintf("%s: readlink failed: %v", link, err))
+			continue
+		}
+		if got, want := strings.TrimSpace(string(out)), release.SymlinkTarget(target, link, relative); got != want {
+			bad = append(bad, fmt.Sprintf("%s -> %s, want %s", link, got, want))
+		}
+	}
+	if len(bad) > 0 {
+		return fmt.Errorf("latest symlink mismatch:\n  %s", strings.Join(bad, "\n  "))
+	}
+	return nil
+}
+
+// updateLatestFileCopies is the copy-mode counterpart of
+// updateLatestFileSymlinks, for servers that do not follow symlinks. Each
+// copy is written next to its alias and renamed over it.
+func updateLatestFileCopies(ctx context.Context, t release.Transport, remoteBase, newVersion string, files []string, channels map[string]string) error {
+	for _, f := range files {
+		target, link := release.LatestLinkPathsIn(remoteBase, newVersion, f, channels[f])
+		tmp := release.ShellQuote(link + ".tmp")
+		cmd := "cp -f " + release.ShellQuote(target) + " " + tmp + " && mv -f " + tmp + " " + release.ShellQuote(link)
+		if err := t.Run(ctx, cmd); err != nil {
+			return fmt.Errorf("copying latest for %s: %w", f, err)
+		}
+	}
+	return nil
+}
+
+// updateLatestFileHardlinks is the hardlink-mode counterpart of
+// updateLatestFileSymlinks. Each link is made next to its alias and renamed
+// over it; ln fails if the two paths are on different filesystems.
+func updateLatestFileHardlinks(ctx context.Context, t release.Transport, remoteBase, newVersion string, files []string, channels map[string]string) error {
+	for _, f := range files {
+		target, link := release.LatestLinkPathsIn(remoteBase, newVersion, f, channels[f])
+		tmp := release.ShellQuote(link + ".tmp")
+		cmd := "ln -f " + release.ShellQuote(target) + " " + tmp + " && mv -f " + tmp + " " + release.ShellQuote(link)
+		if err := t.Run(ctx, cmd); err != nil {
+			return fmt.Errorf("hard-linking latest for %s: %w (hard links need %s and %s on the same filesystem)", f, err, path.Dir(target), path.Dir(link))
+		}
+	}
+	return nil
+}
+
+// verifyLatestHardlinks reports every "-latest" alias that is not the same
+// file as its versioned original.
+func verifyLatestHardlinks(ctx context.Context, t release.Transport, remoteBase, version string, files []string, channels map[string]string) error {
+	var bad []string
+	for _, f := range files {
+		target, link := release.LatestLinkPathsIn(remoteBase, version, f, channels[f])
+		err := t.Run(ctx, "test "+release.ShellQuote(link)+" -ef "+release.ShellQuote(target))
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if err != nil {
+			bad = append(bad, fmt.Sprintf("%s is not a hard link to %s", link, target))
+		}
+	}
+	if len(bad) > 0 {
+		return fmt.Errorf("latest hard link mismatch:\n  %s", strings.Join(bad, "\n  "))
+	}
+	return nil
+}
+
+// verifyLatestCopies hashes each copied "-latest" file and its versioned
+// original on the server, so an interrupted copy that left a stale or
+// partial alias is caught.
+func verifyLatestCopies(ctx context.Context, t release.Transport, tool, remoteBase, version string, files []string, channels map[string]string) error {
+	var bad []string
+	for _, f := range files {
+		target, link := release.LatestLinkPathsIn(remoteBase, version, f, channels[f])
+		want, err := remoteSHA256(ctx, t, tool, target)
+		if err == nil {
+			var got string
+			if got, err = remoteSHA256(ctx, t, tool, link); err == nil && got != want {
+				bad = append(bad, fmt.Sprintf("%s has sha256 %s, %s has %s", link, got, target, want))
+			}
+		}
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if err != nil {
+			bad = append(bad, err.Error())
+		}
+	}
+	if len(bad) > 0 {
+		return fmt.Errorf("latest copy mismatch:\n  %s", strings.Join(bad, "\n  "))
+	}
+	return nil
+}
+
+// verifyRemoteArtifacts hashes the uploaded files on the server and
+// compares them with the checksums recorded in links. The files are hashed
+// by as few tool runs as possible (see remoteSHA256s); an empty tool
+// streams each file back instead (see streamSHA256).
+func verifyRemoteArtifacts(ctx context.Context, t release.Transport, tool, remoteVersionDir string, links []release.DownloadInfo) error {
+	paths := make([]string, len(links))
+	for i, l := range links {
+		paths[i] = remoteVersionDir + "/" + path.Base(l.Link)
+	}
+	var sums map[string]string
+	var failed map[string]error
+	if tool != "" {
+		sums, failed = remoteSHA256s(ctx, t, tool, paths)
+	}
+	var bad []string
+	for i, l := range links {
+		p := paths[i]
+		got, err := sums[p], failed[p]
+		if tool == "" {
+			got, err = streamSHA256(ctx, t, p)
+		}
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if err != nil {
+			bad = append(bad, err.Error())
+			continue
+		}
+		if got != l.Checksum {
+			bad = append(bad, fmt.Sprintf("%s has sha256 %s, manifest has %s", p, got, l.Checksum))
+		}
+	}
+	if len(bad) > 0 {
+		return fmt.Errorf("remote checksum mismatch:\n  %s", strings.Join(bad, "\n  "))
+	}
+	return nil
+}
+
+// remoteChecksumTools are tried in order by -remote-checksum-tool auto:
+// GNU coreutils, then Perl's shasum (macOS), FreeBSD's sha256 and openssl.
+var remoteChecksumTools = []string{"sha256sum", "shasum -a 256", "sha256 -r", "openssl dgst -sha256 -r"}
+
+// emptySHA256 is the digest of no input, used to check a candidate tool.
+const emptySHA256 = "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"
+
+// verifyRemoteListing lists remoteVersionDir and reports artifacts of the
+// entry that are not there and files that neither the entry nor extras
+// account for.
+func verifyRemoteListing(ctx context.Context, t release.Transport, remoteVersionDir string, links []release.DownloadInfo, extras []string) error {
+	out, err := t.Output(ctx, "ls -1A "+release.ShellQuote(remoteVersionDir))
+	if err != nil {
+		return fmt.Errorf("listing %s failed: %w", remoteVersionDir, err)
+	}
+	present := map[string]bool{}
+	for _, name := range strings.Split(string(out), "\n") {
+		if name = strings.TrimSpace(name); name != "" {
+			present[name] = true
+		}
+	}
+	expected := map[string]bool{}
+	for _, f := range extras {
+		expected[f] = true
+	}
+	var bad []string
+	for _, l := range links {
+		name := path.Base(l.Link)
+		expected[name] = true
+		if !present[name] {
+			bad = append(bad, name+": missing")
+		}
+	}
+	var extra []string
+	for name := range present {
+		if !expected[name] {
+			extra = append(extra, name+": not in the manifest")
+		}
+	}
+	sort.Strings(extra)
+	bad = append(bad, extra...)
+	if len(bad) > 0 {
+		return fmt.Errorf("%s does not match the manifest (%d listed, %d expected):\n  %s", remoteVersionDir, len(present), len(links)+len(extras), strings.Join(bad, "\n  "))
+	}
+	return nil
+}
+
+// remoteChgrp changes the group of paths on the server, recursively if
+// asked. chgrp needs the ssh user to own the files and be in group, which
+// the error spells out since the bare "Operation not permitted" does not.
+func remoteChgrp(ctx context.Context, t release.Transport, group string, recursive bool, paths ...string) error {
+	cmd := "chgrp "
+	if recursive {
+		cmd += "-R "
+	}
+	cmd += release.ShellQuote(group)
+	for _, p := range paths {
+		cmd += " " + release.ShellQuote(p)
+	}
+	if err := t.Run(ctx, cmd); err != nil {
+		return fmt.Errorf("chgrp %s %s failed: %w (the ssh user must own the files and be a member of %s)", group, strings.Join(paths, " "), err, group)
+	}
+	return nil
+}
+
+// detectChecksumTool returns the first of remoteChecksumTools that hashes
+// /dev/null correctly on the server.
+func detectChecksumTool(ctx context.Context, t release.Transport) (string, error) {
+	for _, tool := range remoteChecksumTools {
+		out, err := t.Output(ctx, tool+" /dev/null")
+		if ctx.Err() != nil {
+			return "", ctx.Err()
+		}
+		if err == nil && parseDigest(string(out)) == emptySHA256 {
+			return tool, nil
+		}
+	}
+	return "", fmt.Errorf("no sha256 tool found on the server (tried %s)", strings.Join(remoteChecksumTools, ", "))
+}
+
+// remoteSHA256 runs tool on file and returns its hex digest.
+func remoteSHA256(ctx context.Context, t release.Transport, tool, file string) (string, error) {
+	if tool == "" {
+		return streamSHA256(ctx, t, file)
+	}
+	out, err := t.Output(ctx, tool+" "+release.ShellQuote(file))
+	if err != nil {
+		return "", fmt.Errorf("%s: %s failed: %v", file, tool, err)
+	}
+	sum := parseDigest(string(out))
+	if sum == "" {
+		return "", fmt.Errorf("%s: no sha256 digest in %s output %q", file, tool, out)
+	}
+	return sum, nil
+}
+
+// sumBatch is how many files one run of the checksum tool hashes, which
+// keeps the command line far below the server's argument limit.
+const sumBatch = 100
+
+// remoteSHA256s hashes files on the server with one tool run per sumBatch
+// files. Each output line is matched back to its file by name, so a file
+// the tool skipped, e.g. because it is missing, cannot shift the digests
+// of the others; it is reported in failed instead.
+func remoteSHA256s(ctx context.Context, t release.Transport, tool string, files []string) (sums map[string]string, failed map[string]error) {
+	sums, failed = map[string]string{}, map[string]error{}
+	for start := 0; start < len(files); start += sumBatch {
+		batch := files[start:min(start+sumBatch, len(files))]
+		cmd := tool
+		for _, f := range batch {
+			cmd += " " + release.ShellQuote(f)
+		}
+		// a missing file fails the run, but the others are still hashed
+		out, runErr := t.Output(ctx, cmd)
+		if ctx.Err() != nil {
+			return nil, nil
+		}
+		want := map[string]bool{}
+		for _, f := range batch {
+			want[f] = true
+		}
+		for _, line := range strings.Split(string(out), "\n") {
+			if name, sum := parseDigestLine(line); want[name] && sum != "" {
+				sums[name] = sum
+			}
+		}
+		for _, f := range batch {
+			if _, ok := sums[f]; ok {
+				continue
+			}
+			if runErr != nil {
+				failed[f] = fmt.Errorf("%s: %s failed: %v", f, tool, runErr)
+			} else {
+				failed[f] = fmt.Errorf("%s: no sha256 digest in %s output", f, tool)
+			}
+		}
+	}
+	return sums, failed
+}
+
+// digestUnescaper undoes the escaping sha256sum applies to the names on
+// lines that start with a backslash.
+var digestUnescaper = strings.NewReplacer(`\\`, `\`, `\n`, "\n", `\r`, "\r")
+
+// parseDigestLine splits one line of checksum tool output into the file
+// name and its hex digest. It reads "<hex>  file", "<hex> *file" and
+// "<hex> file" (sha256sum, shasum and the -r forms) as well as
+// "SHA256 (file) = <hex>" (BSD and openssl defaults).
+func parseDigestLine(line string) (name, sum string) {
+	line = strings.TrimRight(line, "\r")
+	escaped := strings.HasPrefix(line, `\`)
+	if escaped {
+		line = line[1:]
+	}
+	if len(line) > 65 && line[64] == ' ' && isHexSHA256(line[:64]) {
+		name = line[65:]
+		if name[0] == ' ' || name[0] == '*' {
+			name = name[1:]
+		}
+		if escaped {
+			name = digestUnescaper.Replace(name)
+		}
+		return name, strings.ToLower(line[:64])
+	}
+	open, end := strings.Index(line, "("), strings.LastIndex(line, ")")
+	if open >= 0 && end > open {
+		s := strings.TrimSpace(strings.TrimPrefix(strings.TrimSpace(line[end+1:]), "="))
+		if isHexSHA256(s) {
+			return line[open+1 : end], strings.ToLower(s)
+		}
+	}
+	return "", ""
+}
+
+// streamSHA256 pipes file through cat on the server and hashes it as it
+// arrives, for servers without a sha256 tool. Nothing is saved locally.
+func streamSHA256(ctx context.Context, t release.Transport, file string) (string, error) {
+	s, ok := t.(release.Streamer)
+	if !ok {
+		return "", fmt.Errorf("%s: this transport cannot stream files", file)
+	}
+	h := sha256.New()
+	if err := s.Stream(ctx, "cat -- "+release.ShellQuote(file), h); err != nil {
+		return "", fmt.Errorf("%s: cat failed: %v", file, err)
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// parseDigest finds the hex digest in one line of checksum tool output:
+// first in "<hex>  file" (sha256sum, shasum, -r forms), last in
+// "SHA256 (file) = <hex>" (BSD and openssl defaults).
+func parseDigest(out string) string {
+	line, _, _ := strings.Cut(strings.TrimSpace(out), "\n")
+	fields := strings.Fields(line)
+	if len(fields) == 0 {
+		return ""
+	}
+	for _, f := range []string{strings.TrimPrefix(fields[0], "\\"), fields[len(fields)-1]} {
+		if isHexSHA256(f) {
+			return strings.ToLower(f)
+		}
+	}
+	return ""
+}
+
+func isHexSHA256(s string) bool {
+	if len(s) != 64 {
+		return false
+	}
+	for _, r := range s {
+		if !strings.ContainsRune("0123456789abcdefABCDEF", r) {
+			return false
+		}
+	}
+	return true
+}
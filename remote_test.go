@@ -0,0 +1,44 @@
+package main
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestRemotePaths(t *testing.T) {
+	for _, c := range []struct {
+		dir, downloads, version string
+	}{
+		{"/srv/www", "/srv/www/downloads", "/srv/www/downloads/1.2.3"},
+		{"/srv/www/", "/srv/www/downloads", "/srv/www/downloads/1.2.3"},
+		{"/srv/www//", "/srv/www/downloads", "/srv/www/downloads/1.2.3"},
+		{"/srv/sites/relay/public_html", "/srv/sites/relay/public_html/downloads", "/srv/sites/relay/public_html/downloads/1.2.3"},
+		{"public_html/", "public_html/downloads", "public_html/downloads/1.2.3"},
+		{"/", "/downloads", "/downloads/1.2.3"},
+	} {
+		if got := remoteDownloads(c.dir); got != c.downloads {
+			t.Errorf("remoteDownloads(%q) = %q, want %q", c.dir, got, c.downloads)
+		}
+		if got := remoteVersionPath(c.dir, "1.2.3"); got != c.version {
+			t.Errorf("remoteVersionPath(%q) = %q, want %q", c.dir, got, c.version)
+		}
+	}
+}
+
+func TestRemoteManifests(t *testing.T) {
+	for _, c := range []struct {
+		dir       string
+		manifests []string
+		want      []string
+	}{
+		{"/srv/www", []string{"relayClient.json"}, []string{"/srv/www/relayClient.json"}},
+		{"/srv/www/", []string{"relayClient.json"}, []string{"/srv/www/relayClient.json"}},
+		// only the base name of a local manifest is kept
+		{"/srv/sites/relay/", []string{"out/nested/relayClient.json", "out/relayClient.json.sig"},
+			[]string{"/srv/sites/relay/relayClient.json", "/srv/sites/relay/relayClient.json.sig"}},
+	} {
+		if got := remoteManifests(c.dir, c.manifests); !slices.Equal(got, c.want) {
+			t.Errorf("remoteManifests(%q, %q) = %q, want %q", c.dir, c.manifests, got, c.want)
+		}
+	}
+}
@@ -0,0 +1,204 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"relayUpdater/release"
+)
+
+// replaceArtifact is -replace-artifact: it builds or collects the artifacts
+// of an existing version again, keeps only the named file and swaps it in.
+// The file is uploaded first, then the manifest with its new checksum and
+// size, then its -latest alias is remade if the highest release lists it.
+// The version's other files and links are left alone.
+func replaceArtifact(ctx context.Context, opts *options, remote release.Transport, entries []release.Entry) error {
+	i := release.FindEntry(entries, opts.replaceArtifact)
+	if i < 0 {
+		return &release.ManifestError{Err: fmt.Errorf("-replace-artifact: version %s is not in %s", opts.replaceArtifact, opts.jsonName)}
+	}
+	version, name := entries[i].Version, opts.replaceFile
+	j := slices.IndexFunc(entries[i].Links, func(l release.DownloadInfo) bool {
+		return filepath.Base(l.Link) == name
+	})
+	if j < 0 {
+		return &release.ManifestError{Err: fmt.Errorf("-replace-artifact: version %s has no file %s", version, name)}
+	}
+	old := entries[i].Links[j]
+	versionDir := filepath.Join(dlDir, version)
+	if filepath.Dir(old.Link) != versionDir {
+		return &release.ManifestError{Err: fmt.Errorf("-replace-artifact: version %s lists %s from %s; replace it in that version", version, name, filepath.Dir(old.Link))}
+	}
+
+	// only the target that makes the file is built again
+	bo := opts
+	if targets := splitList(opts.targets); len(targets) > 0 {
+		t := matchTarget(strings.TrimSuffix(name, filepath.Ext(name)), targets)
+		if t == "" {
+			return &release.BuildError{Err: fmt.Errorf("-replace-artifact: %s matches none of -targets %s", name, opts.targets)}
+		}
+		o := *opts
+		o.targets = t
+		bo = &o
+	}
+
+	tmp, err := os.MkdirTemp("", "relay-replace-")
+	if err != nil {
+		return fmt.Errorf("failed to create temp dir: %w", err)
+	}
+	defer os.RemoveAll(tmp)
+	files, err := buildArtifacts(ctx, bo, version, tmp, &releaseMetrics{})
+	if err != nil {
+		return err
+	}
+	if !slices.Contains(files, name) {
+		return &release.BuildError{Err: fmt.Errorf("-replace-artifact: no collected artifact is named %s (got %s)", name, strings.Join(files, ", "))}
+	}
+	// the cache is keyed by path and would only keep the temp dir's
+	link, err := checksumArtifact(opts, nil, filepath.Join(tmp, name))
+	if err != nil {
+		return &release.ChecksumError{Err: err}
+	}
+	link.Link, link.Mirrors = old.Link, old.Mirrors
+	link.Channel, link.MinOSVersion = old.Channel, old.MinOSVersion
+	if link.ContentHash == "" && old.ContentHash != "" {
+		if link.ContentHash, err = release.ContentChecksum(filepath.Join(tmp, name)); err != nil {
+			return &release.ChecksumError{Err: fmt.Errorf("content checksum failed for %s: %w", name, err)}
+		}
+	}
+	if err := crossCheck(opts, []release.DownloadInfo{link}, version); err != nil {
+		return err
+	}
+	if link.Checksum == old.Checksum {
+		fmt.Printf("✅ No changes: %s of version %s is the same as the released file\n", name, version)
+		return errNoChanges
+	}
+	replaceLinks(entries, link)
+
+	if opts.dryRun && !opts.recording() {
+		if _, err := writeManifest(opts, entries, false); err != nil {
+			return err
+		}
+		fmt.Printf("would replace %s (sha256 %s, %d bytes)\n", link.Link, link.Checksum, link.Size)
+		return nil
+	}
+	if err := copyFile(filepath.Join(tmp, name), link.Link); err != nil {
+		return &release.BuildError{Err: fmt.Errorf("failed to copy %s into %s: %w", name, versionDir, err)}
+	}
+	uploads := []string{name}
+	if opts.sumsFile {
+		extras, err := writeSignedSums(ctx, versionDir, opts.gpgKey, entries[i].Links)
+		if err != nil {
+			return err
+		}
+		uploads = append(uploads, extras...)
+	}
+
+	// every copy of the file must be in place before a manifest names it
+	tools := map[string]string{}
+	for _, m := range opts.mirrors {
+		t, err := mirrorRemote(opts, remote, nil, m)
+		if err != nil {
+			return err
+		}
+		tool, err := uploadReplacement(ctx, opts, t, m.dir, version, versionDir, uploads, link)
+		if err != nil {
+			if len(opts.mirrors) > 1 {
+				return fmt.Errorf("%s: %w", m, err)
+			}
+			return err
+		}
+		tools[m.String()] = tool
+	}
+	if err := rewriteManifest(ctx, opts, remote, entries); err != nil {
+		return err
+	}
+	// an -allow-empty release on top may list this file as its own latest
+	h := release.FindEntry(entries, release.HighestVersion(entries).String())
+	if h >= 0 && slices.ContainsFunc(entries[h].Links, func(l release.DownloadInfo) bool { return l.Link == link.Link }) {
+		for _, m := range opts.mirrors {
+			t, err := mirrorRemote(opts, remote, nil, m)
+			if err != nil {
+				return err
+			}
+			if err := pointLatest(ctx, opts, t, os.Stderr, m.dir, tools[m.String()], version, []string{name}, map[string]string{name: link.Channel}); err != nil {
+				if len(opts.mirrors) > 1 {
+					return fmt.Errorf("%s: %w", m, err)
+				}
+				return err
+			}
+		}
+	}
+	if opts.verifyURLs && !opts.recording() {
+		if err := verifyURLs(ctx, opts, []release.DownloadInfo{link}); err != nil {
+			return err
+		}
+	}
+	fmt.Printf("✅ Replaced %s in version %s (sha256 %s)\n", name, version, link.Checksum)
+	return nil
+}
+
+// replaceLinks records link in every entry that lists the same file, such
+// as -allow-empty releases carrying it, and refreshes what is derived from
+// it: dir-sha256 and any recorded -latest aliases.
+func replaceLinks(entries []release.Entry, link release.DownloadInfo) {
+	for k := range entries {
+		e := &entries[k]
+		for m, l := range e.Links {
+			if l.Link != link.Link {
+				continue
+			}
+			e.Links[m] = link
+			if e.DirChecksum != "" {
+				e.DirChecksum = release.DirChecksum(e.Links)
+			}
+		}
+	}
+	for _, e := range entries {
+		if e.Latest != nil {
+			release.RecordLatestAliases(entries, e.Version, dlDir)
+			break
+		}
+	}
+}
+
+// uploadReplacement uploads the replaced file and any extras of version
+// into remoteDir and checks them as a release would. It returns the
+// checksum tool to use on that server.
+func uploadReplacement(ctx context.Context, opts *options, remote release.Transport, remoteDir, version, versionDir string, uploads []string, link release.DownloadInfo) (string, error) {
+	remoteVersionDir := remoteVersionPath(remoteDir, version)
+	tool := opts.remoteTool
+	needTool := opts.verifyRemote && opts.verifyMode == "tool" || opts.latestMode == "copy"
+	if tool == "auto" && needTool && !opts.recording() {
+		var err error
+		if tool, err = detectChecksumTool(ctx, remote); err != nil {
+			return "", &release.UploadError{Err: err}
+		}
+	}
+	var local []string
+	for _, f := range uploads {
+		local = append(local, filepath.Join(versionDir, f))
+	}
+	if err := remote.Upload(ctx, remoteVersionDir, local...); err != nil {
+		return "", &release.UploadError{Err: fmt.Errorf("upload of %s failed: %w", link.Link, err)}
+	}
+	if opts.verifyRemote && !opts.recording() {
+		verifyTool := tool
+		if opts.verifyMode == "stream" {
+			verifyTool = ""
+		}
+		if err := verifyRemoteArtifacts(ctx, remote, verifyTool, remoteVersionDir, []release.DownloadInfo{link}); err != nil {
+			return "", &release.ChecksumError{Err: err}
+		}
+	}
+	if opts.chgrp != "" {
+		if err := remoteChgrp(ctx, remote, opts.chgrp, true, remoteVersionDir); err != nil {
+			return "", &release.UploadError{Err: err}
+		}
+	}
+	return tool, nil
+}
@@ -0,0 +1,202 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+
+	semver "github.com/Masterminds/semver/v3"
+
+	"relayUpdater/release"
+)
+
+// stateFileName lives in dlDir and records how far the last release got.
+const stateFileName = ".release-state.json"
+
+// Release steps, in order. Uploads and latest links are tracked per remote
+// dir as "upload <dir>" and "latest <dir>".
+const (
+	stepBuild    = "build"
+	stepChecksum = "checksum"
+	stepManifest = "manifest"
+	stepUpload   = "upload"
+	stepLatest   = "latest"
+)
+
+// releaseState is the on-disk progress of one release. A nil *releaseState
+// records nothing, which is what dry runs use.
+type releaseState struct {
+	mu   sync.Mutex
+	path string
+
+	Manifest string                 `json:"manifest"`
+	Version  string                 `json:"version"`
+	Steps    []string               `json:"steps"`
+	Files    []string               `json:"files,omitempty"`
+	Links    []release.DownloadInfo `json:"links,omitempty"`
+}
+
+// loadReleaseState reads the state left by a failed release, or returns nil
+// if there is none.
+func loadReleaseState(path string) (*releaseState, error) {
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	s := &releaseState{path: path}
+	if err := json.Unmarshal(data, s); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return s, nil
+}
+
+func (s *releaseState) done(step string) bool {
+	if s == nil {
+		return false
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return slices.Contains(s.Steps, step)
+}
+
+// mark records step as completed and saves the state.
+func (s *releaseState) mark(step string) error {
+	if s == nil {
+		return nil
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.Steps = append(s.Steps, step)
+	data, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return err
+	}
+	// a crash mid-write must not leave a state that cannot be read back
+	tmp := s.path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return fmt.Errorf("failed to save release state: %w", err)
+	}
+	if err := os.Rename(tmp, s.path); err != nil {
+		return fmt.Errorf("failed to save release state: %w", err)
+	}
+	return nil
+}
+
+// markBuilt records the build step along with the files it produced.
+func (s *releaseState) markBuilt(files []string) error {
+	if s == nil {
+		return nil
+	}
+	s.Files = files
+	return s.mark(stepBuild)
+}
+
+// markSummed records the checksum step along with the links it produced.
+func (s *releaseState) markSummed(links []release.DownloadInfo) error {
+	if s == nil {
+		return nil
+	}
+	s.Links = links
+	return s.mark(stepChecksum)
+}
+
+// clear removes the state once the release is complete.
+func (s *releaseState) clear() error {
+	if s == nil {
+		return nil
+	}
+	if err := os.Remove(s.path); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// checkBuilt makes sure the artifacts a resumed release skips building
+// are still intact.
+func (s *releaseState) checkBuilt(ctx context.Context, versionDir string, jobs int) error {
+	if len(s.Files) == 0 {
+		return fmt.Errorf("no artifacts recorded for %s", s.Version)
+	}
+	return validateArchives(ctx, versionDir, s.Files, jobs)
+}
+
+// checkSummed makes sure the artifacts still have the recorded sizes. They
+// are not hashed again; that would cost as much as redoing the step.
+func (s *releaseState) checkSummed() error {
+	for _, l := range s.Links {
+		fi, err := os.Stat(l.Link)
+		if err != nil {
+			return err
+		}
+		if fi.Size() != l.Size {
+			return fmt.Errorf("%s changed since its checksum was taken (%d bytes, was %d)", l.Link, fi.Size(), l.Size)
+		}
+	}
+	return nil
+}
+
+// checkSaved makes sure the manifest still holds the recorded checksums.
+func (s *releaseState) checkSaved(entries []release.Entry) error {
+	i := release.FindEntry(entries, s.Version)
+	if i < 0 {
+		return fmt.Errorf("version %s is no longer in %s", s.Version, s.Manifest)
+	}
+	for _, l := range s.Links {
+		j := slices.IndexFunc(entries[i].Links, func(e release.DownloadInfo) bool { return e.Link == l.Link })
+		if j < 0 || entries[i].Links[j].Checksum != l.Checksum {
+			return fmt.Errorf("%s for %s no longer matches %s", s.Manifest, filepath.Base(l.Link), s.Version)
+		}
+	}
+	return nil
+}
+
+// checkUploaded makes sure every file a resumed release skips uploading is
+// still on the server.
+func checkUploaded(ctx context.Context, t release.Transport, paths []string) error {
+	tests := make([]string, len(paths))
+	for i, p := range paths {
+		tests[i] = "test -f " + release.ShellQuote(p)
+	}
+	if err := t.Run(ctx, strings.Join(tests, " && ")); err != nil {
+		return fmt.Errorf("uploaded files are missing: %w", err)
+	}
+	return nil
+}
+
+// resumeState loads the state of the release -resume-release continues.
+// A -version or -append-to given again must name the same version.
+func resumeState(opts *options) (*releaseState, string, error) {
+	path := filepath.Join(dlDir, stateFileName)
+	s, err := loadReleaseState(path)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to read release state: %w", err)
+	}
+	if s == nil {
+		return nil, "", fmt.Errorf("-resume-release: there is no failed release to resume (%s not found)", path)
+	}
+	if s.Manifest != opts.jsonName {
+		return nil, "", fmt.Errorf("-resume-release: the failed release was for %s, not %s", s.Manifest, opts.jsonName)
+	}
+	for _, want := range []string{opts.manualVer, opts.appendTo} {
+		if want == "" {
+			continue
+		}
+		v, err := semver.NewVersion(want)
+		if err != nil {
+			return nil, "", fmt.Errorf("invalid version %q: %v", want, err)
+		}
+		if sv, err := semver.NewVersion(s.Version); err != nil || !v.Equal(sv) {
+			return nil, "", fmt.Errorf("-resume-release: the failed release was version %s, not %s", s.Version, want)
+		}
+	}
+	fmt.Printf("resuming release of %s; done: %s\n", s.Version, strings.Join(s.Steps, ", "))
+	return s, s.Version, nil
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+
+	"relayUpdater/release"
+)
+
+// failedRelease leaves the state of a release of 1.2.0 to relayClient.json
+// that uploaded to the first of two mirrors and then failed.
+func failedRelease(t *testing.T) {
+	t.Helper()
+	t.Chdir(t.TempDir())
+	if err := os.Mkdir(dlDir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	s := &releaseState{path: filepath.Join(dlDir, stateFileName), Manifest: "relayClient.json", Version: "1.2.0"}
+	links := []release.DownloadInfo{{Link: filepath.Join(dlDir, "1.2.0", "client-1.2.0.zip"), Checksum: "abc", Size: 3}}
+	for _, err := range []error{
+		s.markBuilt([]string{"client-1.2.0.zip"}),
+		s.markSummed(links),
+		s.mark(stepManifest),
+		s.mark(stepUpload + " " + mirror{dir: "/srv/a"}.String()),
+	} {
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestResumeAfterUploadFailure(t *testing.T) {
+	failedRelease(t)
+	s, v, err := resumeState(&options{jsonName: "relayClient.json"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v != "1.2.0" {
+		t.Errorf("resumed version %s, want 1.2.0", v)
+	}
+	for step, want := range map[string]bool{
+		stepBuild:              true,
+		stepChecksum:           true,
+		stepManifest:           true,
+		stepUpload + " /srv/a": true,
+		stepLatest + " /srv/a": false,
+		stepUpload + " /srv/b": false,
+	} {
+		if s.done(step) != want {
+			t.Errorf("done(%q) = %v, want %v", step, !want, want)
+		}
+	}
+	if !slices.Equal(s.Files, []string{"client-1.2.0.zip"}) || len(s.Links) != 1 || s.Links[0].Checksum != "abc" {
+		t.Errorf("resumed files %v and links %+v, want those of the failed release", s.Files, s.Links)
+	}
+
+	// the skipped upload is only trusted while its files are still there
+	remoteDir := t.TempDir()
+	gcTree(t, remoteDir, "1.2.0")
+	tr := &release.LocalTransport{Stdout: io.Discard, Stderr: io.Discard}
+	uploaded := filepath.Join(remoteDir, dlDir, "1.2.0", "client-1.2.0.zip")
+	if err := checkUploaded(context.Background(), tr, []string{uploaded}); err != nil {
+		t.Errorf("checkUploaded: %v", err)
+	}
+	if err := checkUploaded(context.Background(), tr, []string{uploaded, uploaded + ".sig"}); err == nil {
+		t.Error("checkUploaded passed with a file missing")
+	}
+
+	if err := s.clear(); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := resumeState(&options{jsonName: "relayClient.json"}); err == nil {
+		t.Error("resumeState found a release after clear")
+	}
+}
+
+func TestResumeOtherVersion(t *testing.T) {
+	for _, c := range []struct {
+		opts options
+		err  bool
+	}{
+		{opts: options{jsonName: "relayClient.json"}},
+		{opts: options{jsonName: "relayClient.json", manualVer: "1.2.0"}},
+		{opts: options{jsonName: "relayClient.json", appendTo: "1.2.0"}},
+		{opts: options{jsonName: "relayClient.json", manualVer: "1.3.0"}, err: true},
+		{opts: options{jsonName: "relayClient.json", appendTo: "1.1.0"}, err: true},
+		{opts: options{jsonName: "other.json"}, err: true},
+	} {
+		failedRelease(t)
+		_, _, err := resumeState(&c.opts)
+		if (err != nil) != c.err {
+			t.Errorf("resumeState(-version %q -append-to %q -json %s): %v, want error %v", c.opts.manualVer, c.opts.appendTo, c.opts.jsonName, err, c.err)
+		}
+	}
+
+	// a new release of another version starts over, discarding the old state
+	failedRelease(t)
+	s := &releaseState{path: filepath.Join(dlDir, stateFileName), Manifest: "relayClient.json", Version: "1.3.0"}
+	if err := s.markBuilt([]string{"client-1.3.0.zip"}); err != nil {
+		t.Fatal(err)
+	}
+	got, err := loadReleaseState(s.path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Version != "1.3.0" || !slices.Equal(got.Steps, []string{stepBuild}) || got.Links != nil {
+		t.Errorf("state after a new release = %+v, want only the build of 1.3.0", got)
+	}
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"relayUpdater/release"
+)
+
+const sumsFileName = "SHA256SUMS"
+
+// writeSumsFile writes a sha256sum-compatible SHA256SUMS for links into
+// versionDir and returns its path.
+func writeSumsFile(versionDir string, links []release.DownloadInfo) (string, error) {
+	var b strings.Builder
+	for _, l := range links {
+		fmt.Fprintf(&b, "%s  %s\n", l.Checksum, filepath.Base(l.Link))
+	}
+	path := filepath.Join(versionDir, sumsFileName)
+	return path, os.WriteFile(path, []byte(b.String()), 0644)
+}
+
+// signFile makes a detached signature <path>.sig with the given gpg key and
+// checks that it verifies before returning its path.
+func signFile(ctx context.Context, key, path string) (string, error) {
+	sig := path + ".sig"
+	cmd := exec.CommandContext(ctx, "gpg", "--batch", "--yes", "--local-user", key,
+		"--detach-sign", "--output", sig, path)
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	if err := release.RunCommand(ctx, cmd); err != nil {
+		return "", fmt.Errorf("gpg sign %s: %w", path, err)
+	}
+	if err := verifySignature(ctx, sig, path); err != nil {
+		return "", err
+	}
+	return sig, nil
+}
+
+func verifySignature(ctx context.Context, sig, path string) error {
+	cmd := exec.CommandContext(ctx, "gpg", "--batch", "--verify", sig, path)
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	if err := release.RunCommand(ctx, cmd); err != nil {
+		return fmt.Errorf("gpg verify %s: %w", sig, err)
+	}
+	return nil
+}
+
+// checkSigning signs and verifies a throwaway payload with key, so a dry
+// run finds a missing key or an unusable passphrase before a real release
+// does.
+func checkSigning(ctx context.Context, key string) error {
+	dir, err := os.MkdirTemp("", "relay-sign-")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(dir)
+	payload := filepath.Join(dir, "payload")
+	if err := os.WriteFile(payload, []byte("relayUpdater signing check\n"), 0644); err != nil {
+		return err
+	}
+	if _, err := signFile(ctx, key, payload); err != nil {
+		return fmt.Errorf("gpg key %s cannot sign: %w", key, err)
+	}
+	return nil
+}
+
+// writeSignedSums writes SHA256SUMS (and its signature when key is set)
+// and returns the files to upload next to the artifacts.
+func writeSignedSums(ctx context.Context, versionDir, key string, links []release.DownloadInfo) ([]string, error) {
+	sums, err := writeSumsFile(versionDir, links)
+	if err != nil {
+		return nil, fmt.Errorf("failed to write %s: %w", sumsFileName, err)
+	}
+	if key == "" {
+		return []string{filepath.Base(sums)}, nil
+	}
+	sig, err := signFile(ctx, key, sums)
+	if err != nil {
+		return nil, err
+	}
+	return []string{filepath.Base(sums), filepath.Base(sig)}, nil
+}
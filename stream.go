@@ -0,0 +1,103 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"relayUpdater/release"
+)
+
+// streamArtifacts builds like buildArtifacts but sends each zip straight
+// from the build output to the server, hashing it on the way. Nothing is
+// copied into downloads/<version>; the links record the digests of the
+// bytes that were sent. Only one -remote-dir can be streamed to.
+func streamArtifacts(ctx context.Context, opts *options, remote release.Transport, newVersion string, metrics *releaseMetrics) ([]string, []release.DownloadInfo, error) {
+	m := opts.mirrors[0]
+	remote, err := mirrorRemote(opts, remote, nil, m)
+	if err != nil {
+		return nil, nil, err
+	}
+	u, ok := remote.(release.StreamUploader)
+	if !ok {
+		return nil, nil, &release.UploadError{Err: fmt.Errorf("this transport cannot stream uploads")}
+	}
+	targets := splitList(opts.targets)
+	if !opts.skipBuild {
+		if err := runBuild(ctx, opts, newVersion, targets); err != nil {
+			if !opts.allowPartial || ctx.Err() != nil {
+				return nil, nil, &release.BuildError{Err: fmt.Errorf("Build process failed: %w", err)}
+			}
+			fmt.Fprintln(os.Stderr, "warning: build failed, releasing the targets that were built:", err)
+		}
+	}
+	zips, err := sourceZips(opts, opts.srcDir, newVersion, targets)
+	if err != nil {
+		return nil, nil, &release.BuildError{Err: fmt.Errorf("error handling zip files: %w", err)}
+	}
+
+	remoteVersionDir := remoteVersionPath(m.dir, newVersion)
+	if err := remote.MkdirAll(ctx, remoteVersionDir); err != nil {
+		return nil, nil, &release.UploadError{Err: fmt.Errorf("failed to mkdir on remote: %w", err)}
+	}
+	var files []string
+	var links []release.DownloadInfo
+	for _, z := range zips {
+		link, err := streamArtifact(ctx, opts, u, z, newVersion, remoteVersionDir)
+		if err != nil {
+			return nil, nil, err
+		}
+		metrics.addUploaded(z.src)
+		fmt.Printf("streamed %s (%d bytes)\n", z.name, link.Size)
+		files = append(files, z.name)
+		links = append(links, link)
+	}
+	metrics.artifacts = len(links)
+
+	if opts.allowPartial {
+		missing := missingTargets(files, targets)
+		fmt.Printf("targets included: %s\n", strings.Join(subtract(targets, missing), ", "))
+		if len(missing) > 0 {
+			fmt.Printf("targets missing:  %s\n", strings.Join(missing, ", "))
+		}
+	}
+	return files, links, nil
+}
+
+// streamArtifact uploads one zip and returns its manifest link. The link
+// names the file as if it were in downloads/<version>, like any other
+// release, so mirrors and -latest links come out the same.
+func streamArtifact(ctx context.Context, opts *options, u release.StreamUploader, z sourceZip, newVersion, remoteVersionDir string) (release.DownloadInfo, error) {
+	f, err := os.Open(z.src)
+	if err != nil {
+		return release.DownloadInfo{}, &release.BuildError{Err: err}
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	n := &countingWriter{}
+	if err := u.UploadFrom(ctx, io.TeeReader(f, io.MultiWriter(h, n)), path.Join(remoteVersionDir, z.name)); err != nil {
+		return release.DownloadInfo{}, &release.UploadError{Err: err}
+	}
+	fullPath := filepath.Join(dlDir, newVersion, z.name)
+	return release.DownloadInfo{
+		Link:     fullPath,
+		Checksum: hex.EncodeToString(h.Sum(nil)),
+		Mirrors:  release.MirrorURLs(opts.baseURLs, fullPath),
+		Size:     n.n,
+	}, nil
+}
+
+// countingWriter counts the bytes written to it.
+type countingWriter struct{ n int64 }
+
+func (w *countingWriter) Write(p []byte) (int, error) {
+	w.n += int64(len(p))
+	return len(p), nil
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+
+	"relayUpdater/release"
+)
+
+// streamSource creates a -src-dir with two built zips and returns it.
+func streamSource(t *testing.T) string {
+	t.Helper()
+	srcDir := t.TempDir()
+	for _, name := range []string{"client-linux.zip", "client-win.zip"} {
+		if err := os.WriteFile(filepath.Join(srcDir, name), []byte(name), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return srcDir
+}
+
+func TestStreamArtifacts(t *testing.T) {
+	t.Chdir(t.TempDir())
+	remoteDir := t.TempDir()
+	opts := &options{mirrors: []mirror{{dir: remoteDir}}, srcDir: streamSource(t), skipBuild: true}
+	metrics := &releaseMetrics{}
+	files, links, err := streamArtifacts(context.Background(), opts, fakeRemote(t), "1.2.3", metrics)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"client-linux-1.2.3.zip", "client-win-1.2.3.zip"}; !slices.Equal(files, want) {
+		t.Fatalf("files = %v, want %v", files, want)
+	}
+	for i, src := range []string{"client-linux.zip", "client-win.zip"} {
+		sum := sha256.Sum256([]byte(src))
+		want := release.DownloadInfo{Link: filepath.Join(dlDir, "1.2.3", files[i]), Checksum: hex.EncodeToString(sum[:]), Size: int64(len(src))}
+		if l := links[i]; l.Link != want.Link || l.Checksum != want.Checksum || l.Size != want.Size {
+			t.Errorf("link %d = %+v, want %+v", i, l, want)
+		}
+		if b, err := os.ReadFile(filepath.Join(remoteDir, dlDir, "1.2.3", files[i])); err != nil || string(b) != src {
+			t.Errorf("%s on the server = %q, %v; want the contents of %s", files[i], b, err, src)
+		}
+	}
+	if left, _ := os.ReadDir(filepath.Join(remoteDir, dlDir, "1.2.3")); len(left) != 2 {
+		t.Errorf("server has %d files in the version directory, want the 2 artifacts", len(left))
+	}
+	if _, err := os.Stat(dlDir); !os.IsNotExist(err) {
+		t.Errorf("streaming created a local %s: %v", dlDir, err)
+	}
+	if metrics.artifacts != 2 || metrics.bytesUploaded != int64(len("client-linux.zip")+len("client-win.zip")) {
+		t.Errorf("metrics counted %d artifacts of %d bytes", metrics.artifacts, metrics.bytesUploaded)
+	}
+}
+
+func TestStreamArtifactsUploadFails(t *testing.T) {
+	remoteDir := t.TempDir()
+	ssh := filepath.Join(t.TempDir(), "ssh")
+	script := "#!/bin/sh\nfor a; do last=$a; done\ncase $last in *'cat >'*) cat >/dev/null; exit 1 ;; esac\nexec sh -c \"$last\"\n"
+	if err := os.WriteFile(ssh, []byte(script), 0755); err != nil {
+		t.Fatal(err)
+	}
+	remote := &release.SSHTransport{Host: "example.com", User: "deploy", SSHCommand: ssh}
+	opts := &options{mirrors: []mirror{{dir: remoteDir}}, srcDir: streamSource(t), skipBuild: true}
+	_, _, err := streamArtifacts(context.Background(), opts, remote, "1.2.3", &releaseMetrics{})
+	var ue *release.UploadError
+	if !errors.As(err, &ue) {
+		t.Fatalf("streamArtifacts = %v, want an UploadError", err)
+	}
+	if left, _ := os.ReadDir(filepath.Join(remoteDir, dlDir, "1.2.3")); len(left) != 0 {
+		t.Errorf("failed stream left %d files on the server", len(left))
+	}
+}
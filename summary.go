@@ -0,0 +1,49 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"path/filepath"
+	"text/tabwriter"
+
+	"relayUpdater/release"
+)
+
+// summaryArtifact is one artifact in the -output-json release summary.
+type summaryArtifact struct {
+	File    string `json:"file"`
+	Size    int64  `json:"size"`
+	SHA256  string `json:"sha256"`
+	Channel string `json:"channel,omitempty"`
+}
+
+// printSummary writes the artifacts of e with their sizes and sha256s, as
+// an aligned table or, with asJSON, as one JSON object. The checksums are
+// the ones recorded in the manifest, always in hex so they compare with
+// sha256sum output in CI logs.
+func printSummary(w io.Writer, e release.Entry, asJSON bool) error {
+	arts := make([]summaryArtifact, 0, len(e.Links))
+	for _, l := range e.Links {
+		arts = append(arts, summaryArtifact{File: filepath.Base(l.Link), Size: l.Size, SHA256: l.Checksum, Channel: l.Channel})
+	}
+	if asJSON {
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(struct {
+			Version   string            `json:"version"`
+			Artifacts []summaryArtifact `json:"artifacts"`
+		}{e.Version, arts})
+	}
+
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "FILE\tSIZE\tSHA256")
+	for _, a := range arts {
+		name := a.File
+		if a.Channel != "" {
+			name += " (" + a.Channel + ")"
+		}
+		fmt.Fprintf(tw, "%s\t%d\t%s\n", name, a.Size, a.SHA256)
+	}
+	return tw.Flush()
+}
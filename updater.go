@@ -2,211 +2,2737 @@
 package main
 
 import (
-	"crypto/sha256"
-	"encoding/hex"
-	"encoding/json"
+	"bytes"
+	"context"
 	"errors"
 	"flag"
 	"fmt"
 	"io"
-	"log"
+	"io/fs"
+	"net/url"
 	"os"
 	"os/exec"
+	"os/signal"
+	"path"
 	"path/filepath"
+	"runtime"
+	"slices"
+	"strconv"
 	"strings"
+	"sync"
+	"syscall"
 	"time"
 
 	semver "github.com/Masterminds/semver/v3"
+
+	"relayUpdater/release"
+)
+
+const (
+	dlDir = "downloads"
+	// notesFileName is what -upload-notes calls the notes on the server.
+	notesFileName = "RELEASE_NOTES.md"
+)
+
+// version is set at build time with -ldflags "-X main.version=1.2.3" and
+// recorded as the generator of wrapped manifests.
+var version = "dev"
+
+// stringList is a flag that may be given more than once.
+type stringList []string
+
+func (l *stringList) String() string { return strings.Join(*l, ",") }
+
+func (l *stringList) Get() any { return []string(*l) }
+
+func (l *stringList) Set(v string) error {
+	*l = append(*l, v)
+	return nil
+}
+
+// options holds the parsed command-line flags.
+type options struct {
+	dryRun          bool
+	srcDir          string
+	manualVer       string
+	hostPort        string
+	user            string
+	jumpHost        string
+	remoteDirs      stringList
+	jsonName        string
+	appendTo        string
+	checksumKey     string
+	format          string
+	noCache         bool
+	metricsFile     string
+	targets         string
+	verifyLinks     string
+	recordLatest    bool
+	gc              bool
+	gcDelete        bool
+	fromManifest    string
+	verPrefix       string
+	timeout         time.Duration
+	sshCommand      string
+	scpCommand      string
+	transport       string
+	validateOnly    bool
+	validateZips    bool
+	skipBuild       bool
+	sumsFile        bool
+	gpgKey          string
+	baseURLs        stringList
+	touch           string
+	initialVer      string
+	dryRunScript    string
+	srcArchive      string
+	latestMode      string
+	noManifest      bool
+	fetchURL        string
+	fetchDir        string
+	resume          bool
+	verifyRemote    bool
+	remoteTool      string
+	allowPartial    bool
+	rollout         int
+	setRollout      string
+	rolloutArg      string
+	rolloutPct      int
+	jsonIndent      int
+	jsonCompact     bool
+	dedupe          bool
+	checkURL        string
+	current         string
+	clientID        string
+	channel         string
+	allowDowngrade  bool
+	dirChecksum     bool
+	notesFile       string
+	uploadNotes     bool
+	migrate         bool
+	checksumEnc     string
+	skipPreflight   bool
+	sshAlias        string
+	collision       string
+	expectedSums    string
+	mirrorJobs      int
+	meta            stringList
+	diff            bool
+	resumeRelease   bool
+	verifyListing   bool
+	channelMap      stringList
+	gzipManifest    bool
+	linkFailure     string
+	versionFile     string
+	listRemote      bool
+	maxHistory      int
+	fullHistory     string
+	contentHash     bool
+	chgrp           string
+	sftpCommand     string
+	printConfig     bool
+	buildInDocker   bool
+	buildImage      string
+	caFile          string
+	insecureTLS     bool
+	allowEmpty      bool
+	verifyMode      string
+	jobs            int
+	buildRetries    int
+	buildDelay      time.Duration
+	noClobber       bool
+	force           bool
+	feedFile        string
+	feedFormat      string
+	buildEnv        stringList
+	collectErrors   bool
+	remoteTmpDir    string
+	backend         string
+	minOS           stringList
+	cleanTree       bool
+	allowDirty      bool
+	streamUpload    bool
+	setLatest       string
+	keepGoing       bool
+	analyticsURL    string
+	since           string
+	sinceTime       time.Time
+	bundleUpload    bool
+	sanitizeNames   bool
+	dryRunRemote    bool
+	artifactsFrom   string
+	linkStyle       string
+	manifestAlias   string
+	maxClockSkew    time.Duration
+	replaceArtifact string
+	replaceFile     string
+	quiet           bool
+	outputJSON      bool
+	acceptNewKeys   bool
+	strictKeys      bool
+	knownHosts      string
+	verifyURLs      bool
+
+	// manifest is how manifests are written, set up by run from the flags
+	manifest release.ManifestOptions
+	// mirrors are the parsed -remote-dir values
+	mirrors []mirror
+}
+
+// recording reports whether a dry run writes its remote commands to a
+// script instead of skipping them.
+func (o *options) recording() bool {
+	return o.dryRun && o.dryRunScript != ""
+}
+
+// usesSSH reports whether the run may start ssh or scp: it publishes or
+// queries the server with -backend ssh, instead of running a client,
+// -migrate, -validate-only or -print-config mode.
+func (o *options) usesSSH() bool {
+	if o.backend != "ssh" {
+		return false
+	}
+	return o.fetchURL == "" && o.checkURL == "" && !o.migrate && !o.validateOnly && !o.printConfig
+}
+
+func parseFlags() *options {
+	o := &options{}
+	flag.BoolVar(&o.dryRun, "dry-run", false, "do not upload via ssh (testing)")
+	flag.StringVar(&o.srcDir, "src-dir", "../RelayClient", "directory to scan for .zip files")
+	flag.StringVar(&o.manualVer, "version", "", "manually specify new version (format a.b.c)")
+	flag.StringVar(&o.hostPort, "host", "host.ext", "SSH [user@]host[:port]; a user given here replaces -user")
+	flag.StringVar(&o.user, "user", "user", "SSH username")
+	flag.StringVar(&o.jumpHost, "jump-host", "", "SSH jump host / bastion as [user@]host[:port]")
+	flag.Var(&o.remoteDirs, "remote-dir", "remote directory, or [user@]host[:port]:/dir on a host other than -host; repeat to publish to several mirrors (default /home/user/www/public_html)")
+	flag.StringVar(&o.jsonName, "json", "relayClient.json", "name of JSON file")
+	flag.StringVar(&o.appendTo, "append-to", "", "add new artifacts to this existing version instead of releasing a new one")
+	flag.StringVar(&o.checksumKey, "checksum-key", "sha256", "JSON key for checksums: sha256, hash (legacy clients) or both")
+	flag.StringVar(&o.format, "manifest-format", release.FormatJSON, "manifest format: json (array), jsonl (one entry per line, append-only) or wrapped (object with entries and generator)")
+	flag.BoolVar(&o.noCache, "no-checksum-cache", false, "always re-hash artifacts instead of using "+release.ChecksumCacheFile)
+	flag.StringVar(&o.metricsFile, "metrics-file", "", "write release metrics to this file in Prometheus textfile format")
+	flag.StringVar(&o.targets, "targets", "", "comma-separated build targets (e.g. linux,win); default builds and collects all")
+	flag.StringVar(&o.verifyLinks, "verify-latest", "fail", "check the -latest symlinks after updating them: fail, warn or off")
+	flag.BoolVar(&o.recordLatest, "record-latest-checksums", false, "list the -latest aliases and their checksums under the newest manifest entry")
+	flag.BoolVar(&o.gc, "gc", false, "report remote files under downloads/ that nothing references, then exit")
+	flag.BoolVar(&o.gcDelete, "gc-delete", false, "with -gc, delete the orphaned files")
+	flag.StringVar(&o.fromManifest, "from-manifest", "", "publish this already built version from the manifest and downloads/<version> without rebuilding")
+	flag.StringVar(&o.verPrefix, "version-prefix", "", `prefix for stored versions and filenames: "" or "v"`)
+	flag.BoolVar(&o.sumsFile, "sha256sums", false, "write and upload a SHA256SUMS file next to the artifacts")
+	flag.StringVar(&o.gpgKey, "gpg-key", "", "sign SHA256SUMS with this gpg key, producing SHA256SUMS.sig (requires -sha256sums)")
+	flag.DurationVar(&o.timeout, "timeout", 0, "abort the whole run after this long (e.g. 30m); 0 means no limit")
+	flag.StringVar(&o.sshCommand, "ssh-command", "ssh", "ssh-compatible binary used for remote commands")
+	flag.StringVar(&o.scpCommand, "scp-command", "scp", "scp-compatible binary used for uploads")
+	flag.StringVar(&o.transport, "transport", "scp", "how to upload: scp, rsync (falls back to scp if rsync is missing) or sftp (atomic replace)")
+	flag.BoolVar(&o.validateOnly, "validate-only", false, "build, collect, validate and checksum the artifacts, print the result and exit without changing anything")
+	flag.BoolVar(&o.validateZips, "validate-archives", false, "check that every artifact is a readable zip before releasing")
+	flag.BoolVar(&o.skipBuild, "skip-build", false, "do not run build-all.sh; collect the zips already in -src-dir")
+	flag.Var(&o.baseURLs, "base-url", "public URL of the remote directory; repeat for mirrors to list every artifact's mirror URLs")
+	flag.StringVar(&o.touch, "touch", "", "bump the date of this existing version and re-upload only the manifest")
+	flag.StringVar(&o.initialVer, "initial-version", "0.0.1", "version of the first release when the manifest has none and -version is not given")
+	flag.StringVar(&o.dryRunScript, "dry-run-script", "", "with -dry-run, write the ssh/scp commands that would run to this shell script")
+	flag.StringVar(&o.srcArchive, "src-archive", "", "take the platform zips from this zip/tar of build outputs instead of building and scanning -src-dir")
+	flag.StringVar(&o.latestMode, "latest-mode", "symlink", "how the -latest aliases are made: symlink, or copy or hardlink for servers that do not follow symlinks")
+	flag.BoolVar(&o.noManifest, "no-manifest-upload", false, "upload artifacts and update the -latest aliases but leave the remote manifest alone; the local one is still written")
+	flag.StringVar(&o.fetchURL, "fetch", "", "client mode: download a release listed in the manifest at this URL (the highest, or -version) and verify it")
+	flag.StringVar(&o.fetchDir, "fetch-dir", ".", "with -fetch, directory to download into")
+	flag.BoolVar(&o.resume, "resume", false, "with -fetch, continue partial downloads with HTTP Range requests")
+	flag.BoolVar(&o.verifyRemote, "verify-remote", false, "after uploading, hash each artifact on the server and compare with the manifest")
+	flag.StringVar(&o.remoteTool, "remote-checksum-tool", "sha256sum", `sha256 command on the server, e.g. "shasum -a 256", or "auto" to detect one`)
+	flag.BoolVar(&o.allowPartial, "allow-partial", false, "with -targets, release whatever targets were built and record the missing ones in the manifest")
+	flag.IntVar(&o.rollout, "rollout", 100, "percentage (0-100) of clients that should auto-update to this release; 100 leaves the field out")
+	flag.StringVar(&o.setRollout, "set-rollout", "", "set the rollout percentage of an existing `version` to the argument after it (-set-rollout 1.2.3 100) and re-upload only the manifest")
+	flag.IntVar(&o.jsonIndent, "json-indent", 2, "spaces per level in json and wrapped manifests; 0 writes them on one line")
+	flag.BoolVar(&o.jsonCompact, "json-compact", false, "write json and wrapped manifests on one line (same as -json-indent 0)")
+	flag.BoolVar(&o.dedupe, "dedupe-storage", false, "link artifacts whose sha256 an older version already has to that file on the server instead of uploading a copy")
+	flag.StringVar(&o.checkURL, "check-update", "", "client mode: report whether the manifest at this URL has a release newer than -current (exit 0: up to date, 10: update available)")
+	flag.StringVar(&o.current, "current", "", "with -check-update, the version installed now")
+	flag.StringVar(&o.clientID, "client-id", "", "with -check-update, stable ID that places this client in staged rollouts (default: host name)")
+	flag.StringVar(&o.channel, "channel", "", "with -fetch or -check-update, follow this release channel (e.g. beta): its artifacts are offered along with the stable ones; default stable only")
+	flag.BoolVar(&o.allowDowngrade, "allow-downgrade", false, "allow -version to be at or below the highest released version")
+	flag.BoolVar(&o.dirChecksum, "dir-checksum", false, `store one sha256 over all artifact checksums of the entry (sorted by file name) as "dir-sha256"`)
+	flag.StringVar(&o.notesFile, "notes-file", "", "store the contents of this markdown/text file as the release notes of the entry")
+	flag.BoolVar(&o.uploadNotes, "upload-notes", false, "also upload the entry's release notes as <version>/"+notesFileName)
+	flag.BoolVar(&o.migrate, "migrate", false, "read the manifest in any supported shape, update it to the current schema in -manifest-format, print what changed and exit")
+	flag.StringVar(&o.checksumEnc, "checksum-encoding", release.EncodingHex, `how manifest checksums are written: hex, or sri ("sha256-<base64>")`)
+	flag.BoolVar(&o.skipPreflight, "skip-build-connectivity", false, "do not check that the host can be reached and logged into before building")
+	flag.StringVar(&o.sshAlias, "ssh-alias", "", "connect to this Host alias from ~/.ssh/config instead of -host; {host} in -base-url becomes its real host name")
+	flag.StringVar(&o.collision, "collision-policy", "overwrite", "when downloads/<version> already has a file of the same name: overwrite, skip (keep it) or error")
+	flag.StringVar(&o.expectedSums, "expected-checksums", "", "fail unless every artifact matches this file name -> sha256 map (JSON object or sha256sum text)")
+	flag.IntVar(&o.mirrorJobs, "mirror-jobs", 1, "publish to up to this many -remote-dir mirrors at once; above 1, every mirror is tried and their errors are collected")
+	flag.Var(&o.meta, "manifest-meta", "KEY=VALUE for the \"meta\" object of a wrapped manifest; repeatable, KEY= removes a key")
+	flag.BoolVar(&o.diff, "diff", false, "print a unified diff of the local manifest before writing it (implied by -dry-run)")
+	flag.BoolVar(&o.resumeRelease, "resume-release", false, "continue the release that failed last time, skipping the steps it completed")
+	flag.BoolVar(&o.verifyListing, "verify-listing", false, "after uploading, list the remote version directory and fail if files are missing or unexpected (implied by -verify-remote)")
+	flag.Var(&o.channelMap, "channel-map", "TARGET=CHANNEL: release the artifacts of TARGET in CHANNEL (e.g. Plugin=beta); repeatable, default stable")
+	flag.BoolVar(&o.gzipManifest, "gzip-manifest", false, "also write and upload a gzipped copy of the manifest (<json>.gz)")
+	flag.StringVar(&o.linkFailure, "symlink-failure-mode", "fail", "when the -latest links cannot be made: fail, warn (keep the upload, skip the links) or fallback-copy (use -latest-mode copy)")
+	flag.StringVar(&o.versionFile, "version-file", "", "read the new version from this file (e.g. VERSION) when -version is not given")
+	flag.BoolVar(&o.listRemote, "list-remote", false, "list the versions and files on the server, compare them with the manifest, then exit")
+	flag.IntVar(&o.maxHistory, "max-history", 0, "keep only the N newest versions in the written manifest; remote files are not deleted (0 = all)")
+	flag.StringVar(&o.fullHistory, "full-history-file", "", "with -max-history, keep every version in this local file and read the manifest from it")
+	flag.BoolVar(&o.contentHash, "content-hash", false, "also record a sha256 of each archive's contents (file names and data), independent of compression")
+	flag.StringVar(&o.chgrp, "remote-chgrp", "", "after uploading, chgrp the version directory and the manifest to this group on the server")
+	flag.StringVar(&o.sftpCommand, "sftp-command", "sftp", "sftp binary to run for -transport sftp")
+	flag.BoolVar(&o.printConfig, "print-config", false, "print the effective value of every flag and whether it was set or defaulted as JSON, then exit")
+	flag.BoolVar(&o.buildInDocker, "build-in-docker", false, "run build-all.sh inside a docker container of -build-image instead of on this host")
+	flag.StringVar(&o.buildImage, "build-image", "", "with -build-in-docker, the image to build in (e.g. golang:1.24)")
+	flag.StringVar(&o.caFile, "ca-file", "", "with -fetch, -check-update or -verify-urls, also trust the CA certificates in this PEM file")
+	flag.BoolVar(&o.insecureTLS, "insecure-skip-verify", false, "with -fetch, -check-update or -verify-urls, do not check TLS certificates (testing only)")
+	flag.BoolVar(&o.allowEmpty, "allow-empty", false, "when no .zip files are found, release the new version with the previous release's files, e.g. to change only its notes or rollout")
+	flag.StringVar(&o.verifyMode, "remote-verify-mode", "tool", "how -verify-remote hashes uploads: tool (-remote-checksum-tool on the server) or stream (cat them back over ssh and hash locally)")
+	flag.IntVar(&o.jobs, "jobs", runtime.NumCPU(), "check up to this many archives at once with -validate-archives")
+	flag.IntVar(&o.buildRetries, "build-retries", 0, "run build-all.sh up to this many more times if it fails, e.g. for flaky downloads")
+	flag.DurationVar(&o.buildDelay, "build-retry-delay", 0, "with -build-retries, wait this long before each retry")
+	flag.BoolVar(&o.noClobber, "no-clobber", false, "before uploading, check that the remote manifest is absent, empty or lists only versions the local one has")
+	flag.BoolVar(&o.force, "force", false, "with -no-clobber, replace a remote manifest that looks like another project's anyway")
+	flag.StringVar(&o.feedFile, "feed-file", "", "also write the releases as an RSS or Atom feed to this file and upload it next to the manifest")
+	flag.StringVar(&o.feedFormat, "feed-format", release.FeedRSS, "format of -feed-file: rss or atom")
+	flag.Var(&o.buildEnv, "build-env", "KEY=VALUE added to the environment of build-all.sh, with {version} replaced by the version being released (e.g. LDFLAGS=\"-X main.version={version}\"); repeatable")
+	flag.BoolVar(&o.collectErrors, "collect-errors", false, "checksum and upload every artifact even after one fails, then report all failures together")
+	flag.StringVar(&o.remoteTmpDir, "remote-tmp-dir", "", "upload into this directory on the server first, then mv files into place; must be on the same filesystem as -remote-dir (default: upload in place)")
+	flag.StringVar(&o.backend, "backend", "ssh", "where to publish: ssh, or local to copy into -remote-dir on this machine (no network; for tests and local mirrors)")
+	flag.Var(&o.minOS, "min-os-version", "[TARGET=]VERSION: record the oldest OS version the artifacts (of TARGET, e.g. Mac=11.0) run on; repeatable")
+	flag.BoolVar(&o.cleanTree, "require-clean-tree", false, "refuse to build if git status in -src-dir shows uncommitted changes")
+	flag.BoolVar(&o.allowDirty, "allow-dirty", false, "release even if -require-clean-tree finds uncommitted changes")
+	flag.BoolVar(&o.streamUpload, "stream-upload", false, "send each artifact from -src-dir straight to the server while hashing it, without copying it into downloads/<version>")
+	flag.StringVar(&o.setLatest, "set-latest", "", "point the -latest aliases at the files of this existing `version`, e.g. an older known-good one, without changing the manifest")
+	flag.BoolVar(&o.keepGoing, "keep-going", false, "do everything that can be done after a failure: every artifact, -latest alias and -remote-dir is tried, then the failures are reported together (implies -collect-errors)")
+	flag.StringVar(&o.analyticsURL, "analytics-url", "", "record this http(s) URL in the entry as \"analytics-url\", for clients to POST to after a successful update")
+	flag.StringVar(&o.since, "since", "", "with -list-remote or -feed-file, only include releases since this RFC 3339 time, date (2006-01-02) or age (e.g. 30d, 2w, 12h)")
+	flag.BoolVar(&o.bundleUpload, "bundle-upload", false, "upload the files of a version as one tar, unpacked with tar -xf on the server and then removed")
+	flag.BoolVar(&o.sanitizeNames, "sanitize-names", false, "replace characters that are not URL-safe in artifact file names with _ instead of only warning about them")
+	flag.BoolVar(&o.dryRunRemote, "dry-run-remote", false, "log in and check that every remote directory a release writes to is writable, using temporary files, then exit without changing anything")
+	flag.StringVar(&o.artifactsFrom, "artifacts-from", "", "release the .zip files listed in this file, one path per line (\"-\" reads stdin), instead of scanning -src-dir")
+	flag.StringVar(&o.linkStyle, "symlink-target-style", "absolute", "what symlinks on the server store: absolute paths, or paths relative to the link (e.g. 0.2.5/client-0.2.5.zip) that survive a moved webroot")
+	flag.StringVar(&o.manifestAlias, "manifest-alias", "", "after uploading the manifest, point this stable name next to it (e.g. relayClient-latest.json) at it, in -latest-mode, so clients keep one URL if -json changes")
+	flag.DurationVar(&o.maxClockSkew, "max-clock-skew", 0, "before releasing, compare this machine's clock with the server's and warn if they differ by more than this (e.g. 2m); 0 skips the check")
+	flag.StringVar(&o.replaceArtifact, "replace-artifact", "", "rebuild or collect the artifacts of an existing `version`, then replace only the file named by the argument after it (-replace-artifact 1.2.3 client-1.2.3-linux.zip): its copy on the server, its manifest link and its -latest alias")
+	flag.BoolVar(&o.quiet, "quiet", false, "do not print the table of artifacts, sizes and sha256s after a release")
+	flag.BoolVar(&o.outputJSON, "output-json", false, "print the summary after a release as JSON (version and each artifact's file, size and sha256) instead of a table")
+	flag.BoolVar(&o.acceptNewKeys, "accept-new-host-keys", false, "let ssh and scp trust and remember the key of a host they have not seen (StrictHostKeyChecking=accept-new) instead of prompting; a changed key still fails")
+	flag.BoolVar(&o.strictKeys, "strict-host-keys", false, "make ssh and scp refuse hosts whose key is not already known (StrictHostKeyChecking=yes) instead of prompting")
+	flag.StringVar(&o.knownHosts, "known-hosts-file", "", "check host keys against this file instead of ~/.ssh/known_hosts (UserKnownHostsFile), e.g. one kept with the CI config")
+	flag.BoolVar(&o.verifyURLs, "verify-urls", false, "after publishing, send an HTTP HEAD for each artifact on every -base-url and fail unless each answers 200 with the uploaded size")
+	flag.Parse()
+	// the percentage after -set-rollout <version> ends flag parsing, so
+	// take it and go on with any flags that follow
+	if o.setRollout != "" && flag.NArg() > 0 {
+		o.rolloutArg = flag.Arg(0)
+		flag.CommandLine.Parse(flag.Args()[1:])
+	}
+	// likewise the file name after -replace-artifact <version>
+	if o.replaceArtifact != "" && flag.NArg() > 0 {
+		o.replaceFile = flag.Arg(0)
+		flag.CommandLine.Parse(flag.Args()[1:])
+	}
+	if len(o.remoteDirs) == 0 {
+		o.remoteDirs = stringList{"/home/user/www/public_html"}
+	}
+	return o
+}
+
+// validate checks flag values and combinations before any work is done.
+func (o *options) validate() error {
+	if flag.NArg() > 0 {
+		return fmt.Errorf("unexpected arguments: %s", strings.Join(flag.Args(), " "))
+	}
+	if o.setRollout != "" {
+		if o.rolloutArg == "" {
+			return errors.New("-set-rollout wants a version and a percentage: -set-rollout 1.2.3 100")
+		}
+		pct, err := strconv.Atoi(o.rolloutArg)
+		if err != nil || pct < 0 || pct > 100 {
+			return fmt.Errorf("invalid -set-rollout percentage %q (want 0-100)", o.rolloutArg)
+		}
+		o.rolloutPct = pct
+	}
+	for _, f := range []struct{ name, val string }{
+		{"src-dir", o.srcDir},
+		{"host", o.hostPort},
+		{"user", o.user},
+		{"json", o.jsonName},
+	} {
+		if f.val == "" {
+			return fmt.Errorf("-%s must not be empty", f.name)
+		}
+	}
+	if hostUser, _, _, err := release.ParseLogin(o.hostPort); err != nil {
+		return fmt.Errorf("invalid -host: %v", err)
+	} else if hostUser != "" {
+		set := map[string]bool{}
+		flag.Visit(func(f *flag.Flag) { set[f.Name] = true })
+		if set["user"] && o.user != hostUser {
+			return fmt.Errorf("-host %s names user %s, but -user is %s; give only one", o.hostPort, hostUser, o.user)
+		}
+		o.user = hostUser
+	}
+	if o.jumpHost != "" {
+		if _, err := release.ParseJumpHost(o.jumpHost); err != nil {
+			return fmt.Errorf("invalid -jump-host: %v", err)
+		}
+	}
+	if o.gcDelete && !o.gc {
+		return errors.New("-gc-delete requires -gc")
+	}
+	if o.skipBuild && o.fromManifest != "" {
+		return errors.New("-skip-build has no effect with -from-manifest, which does not build")
+	}
+	if o.allowEmpty && o.appendTo != "" {
+		return errors.New("-allow-empty has no effect with -append-to, which needs new artifacts")
+	}
+	if o.caFile != "" && o.insecureTLS {
+		return errors.New("-ca-file has no effect with -insecure-skip-verify")
+	}
+	if o.buildInDocker && o.buildImage == "" {
+		return errors.New("-build-in-docker requires -build-image")
+	}
+	if o.buildImage != "" && !o.buildInDocker {
+		return errors.New("-build-image requires -build-in-docker")
+	}
+	// a version of only whitespace would otherwise read as "not given"
+	for _, f := range []struct {
+		name string
+		val  *string
+	}{
+		{"version", &o.manualVer},
+		{"append-to", &o.appendTo},
+		{"from-manifest", &o.fromManifest},
+		{"touch", &o.touch},
+		{"set-rollout", &o.setRollout},
+		{"set-latest", &o.setLatest},
+		{"replace-artifact", &o.replaceArtifact},
+	} {
+		if *f.val != "" && strings.TrimSpace(*f.val) == "" {
+			return fmt.Errorf("-%s must not be blank", f.name)
+		}
+		*f.val = strings.TrimSpace(*f.val)
+	}
+	if o.verPrefix != "" && o.verPrefix != "v" {
+		return fmt.Errorf(`invalid -version-prefix %q (want "" or "v")`, o.verPrefix)
+	}
+	// client modes and -backend local never start ssh or scp, and must
+	// work on machines that have neither
+	if o.usesSSH() {
+		for _, f := range []struct{ name, val string }{
+			{"ssh-command", o.sshCommand},
+			{"scp-command", o.scpCommand},
+		} {
+			if _, err := exec.LookPath(f.val); err != nil {
+				return fmt.Errorf("invalid -%s: %v", f.name, err)
+			}
+		}
+	}
+	for _, b := range o.baseURLs {
+		u, err := url.Parse(strings.ReplaceAll(b, "{host}", "example.com"))
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return fmt.Errorf("invalid -base-url %q: want an http(s) URL", b)
+		}
+	}
+	if o.transport != "scp" && o.transport != "rsync" && o.transport != "sftp" {
+		return fmt.Errorf("invalid -transport %q (want scp, rsync or sftp)", o.transport)
+	}
+	if o.gpgKey != "" && !o.sumsFile {
+		return errors.New("-gpg-key requires -sha256sums")
+	}
+	// at most one mode besides a plain release; -version only picks the
+	// version of a new release, which -validate-only also builds, or the
+	// version -fetch downloads
+	var modes []string
+	for _, m := range []struct {
+		name string
+		set  bool
+	}{
+		{"append-to", o.appendTo != ""},
+		{"from-manifest", o.fromManifest != ""},
+		{"gc", o.gc},
+		{"validate-only", o.validateOnly},
+		{"touch", o.touch != ""},
+		{"fetch", o.fetchURL != ""},
+		{"check-update", o.checkURL != ""},
+		{"migrate", o.migrate},
+		{"set-rollout", o.setRollout != ""},
+		{"set-latest", o.setLatest != ""},
+		{"list-remote", o.listRemote},
+		{"dry-run-remote", o.dryRunRemote},
+		{"replace-artifact", o.replaceArtifact != ""},
+	} {
+		if m.set {
+			modes = append(modes, "-"+m.name)
+		}
+	}
+	if len(modes) > 1 {
+		return fmt.Errorf("%s cannot be combined", strings.Join(modes, " and "))
+	}
+	if o.versionFile != "" {
+		if len(modes) == 1 && modes[0] != "-validate-only" {
+			return fmt.Errorf("-version-file cannot be combined with %s", modes[0])
+		}
+		// an explicit -version wins over the file
+		if o.manualVer == "" {
+			v, err := readVersionFile(o.versionFile)
+			if err != nil {
+				return err
+			}
+			o.manualVer = v
+		}
+	}
+	if o.manualVer != "" && len(modes) == 1 && modes[0] != "-validate-only" && modes[0] != "-fetch" {
+		return fmt.Errorf("-version cannot be combined with %s", modes[0])
+	}
+	for _, f := range []struct{ name, val string }{
+		{"version", o.manualVer},
+		{"append-to", o.appendTo},
+		{"from-manifest", o.fromManifest},
+		{"touch", o.touch},
+		{"initial-version", o.initialVer},
+		{"set-rollout", o.setRollout},
+		{"set-latest", o.setLatest},
+		{"replace-artifact", o.replaceArtifact},
+	} {
+		if f.val == "" {
+			continue
+		}
+		if _, err := semver.NewVersion(f.val); err != nil {
+			return fmt.Errorf("invalid -%s %q: %v", f.name, f.val, err)
+		}
+	}
+	if _, err := release.ParseChecksumKey(o.checksumKey); err != nil {
+		return fmt.Errorf("invalid -checksum-key: %v", err)
+	}
+	if o.fromManifest != "" && o.targets != "" {
+		return errors.New("-targets has no effect with -from-manifest, which does not build")
+	}
+	if o.targets != "" && len(splitList(o.targets)) == 0 {
+		return errors.New("-targets has no target names")
+	}
+	switch o.verifyLinks {
+	case "fail", "warn", "off":
+	default:
+		return fmt.Errorf("invalid -verify-latest %q (want fail, warn or off)", o.verifyLinks)
+	}
+	switch o.format {
+	case release.FormatJSON, release.FormatJSONL, release.FormatWrapped:
+	default:
+		return fmt.Errorf("invalid -manifest-format %q (want %s, %s or %s)", o.format, release.FormatJSON, release.FormatJSONL, release.FormatWrapped)
+	}
+	if o.manifestAlias != "" {
+		if o.noManifest {
+			return errors.New("-manifest-alias cannot be combined with -no-manifest-upload")
+		}
+		if strings.ContainsAny(o.manifestAlias, "/\\") || o.manifestAlias == "." || o.manifestAlias == ".." {
+			return fmt.Errorf("invalid -manifest-alias %q: want a file name", o.manifestAlias)
+		}
+		if o.manifestAlias == filepath.Base(o.jsonName) {
+			return errors.New("-manifest-alias must differ from the -json file name")
+		}
+	}
+	if o.linkStyle != "absolute" && o.linkStyle != "relative" {
+		return fmt.Errorf("invalid -symlink-target-style %q (want absolute or relative)", o.linkStyle)
+	}
+	if o.backend != "ssh" && o.backend != "local" {
+		return fmt.Errorf("invalid -backend %q (want ssh or local)", o.backend)
+	}
+	if o.backend == "local" && o.dryRunScript != "" {
+		return errors.New("-dry-run-script records ssh commands and does not work with -backend local")
+	}
+	if o.dryRunScript != "" && !o.dryRun {
+		return errors.New("-dry-run-script requires -dry-run")
+	}
+	if o.srcArchive != "" && (o.fromManifest != "" || o.touch != "" || o.gc) {
+		return errors.New("-src-archive only applies to a release that collects artifacts")
+	}
+	if o.latestMode != "symlink" && o.latestMode != "copy" && o.latestMode != "hardlink" {
+		return fmt.Errorf("invalid -latest-mode %q (want symlink, copy or hardlink)", o.latestMode)
+	}
+	if o.noManifest && (o.touch != "" || o.gc) {
+		return errors.New("-no-manifest-upload does not apply to -touch or -gc")
+	}
+	if o.fetchURL != "" {
+		u, err := url.Parse(o.fetchURL)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return fmt.Errorf("invalid -fetch %q: want an http(s) URL", o.fetchURL)
+		}
+	}
+	if o.since != "" {
+		if !o.listRemote && o.feedFile == "" {
+			return errors.New("-since only applies to -list-remote and -feed-file")
+		}
+		t, err := parseSince(o.since, time.Now())
+		if err != nil {
+			return err
+		}
+		o.sinceTime = t
+	}
+	if o.analyticsURL != "" {
+		u, err := url.Parse(o.analyticsURL)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return fmt.Errorf("invalid -analytics-url %q: want an http(s) URL", o.analyticsURL)
+		}
+	}
+	if o.resume && o.fetchURL == "" {
+		return errors.New("-resume requires -fetch")
+	}
+	if o.verifyMode != "tool" && o.verifyMode != "stream" {
+		return fmt.Errorf("invalid -remote-verify-mode %q (want tool or stream)", o.verifyMode)
+	}
+	if strings.TrimSpace(o.remoteTool) == "" {
+		return errors.New("-remote-checksum-tool must not be empty")
+	}
+	if o.allowPartial && o.targets == "" {
+		return errors.New("-allow-partial requires -targets")
+	}
+	if o.rollout < 0 || o.rollout > 100 {
+		return fmt.Errorf("invalid -rollout %d (want 0-100)", o.rollout)
+	}
+	if o.rollout != 100 && len(modes) > 0 && modes[0] != "-validate-only" {
+		return fmt.Errorf("-rollout only applies to a new release, not %s; use -set-rollout to change an existing one", modes[0])
+	}
+	if o.jsonIndent < 0 || o.jsonIndent > 8 {
+		return fmt.Errorf("invalid -json-indent %d (want 0-8)", o.jsonIndent)
+	}
+	if o.jsonCompact {
+		// an explicit -json-indent conflicts even when it is 0 or 2
+		set := map[string]bool{}
+		flag.Visit(func(f *flag.Flag) { set[f.Name] = true })
+		if set["json-indent"] {
+			return errors.New("-json-compact cannot be combined with -json-indent")
+		}
+		o.jsonIndent = 0
+	}
+	if o.dedupe && (o.touch != "" || o.gc || o.setRollout != "") {
+		return errors.New("-dedupe-storage only applies to uploads")
+	}
+	if o.checkURL != "" {
+		u, err := url.Parse(o.checkURL)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return fmt.Errorf("invalid -check-update %q: want an http(s) URL", o.checkURL)
+		}
+		if _, err := semver.NewVersion(o.current); err != nil {
+			return fmt.Errorf("-check-update needs -current set to a valid version: %v", err)
+		}
+		if o.clientID == "" {
+			o.clientID, _ = os.Hostname()
+		}
+	} else if o.current != "" || o.clientID != "" {
+		return errors.New("-current and -client-id require -check-update")
+	}
+	if o.channel != "" {
+		if o.checkURL == "" && o.fetchURL == "" {
+			return errors.New("-channel only applies to -fetch and -check-update")
+		}
+		if !validChannel(o.channel) {
+			return fmt.Errorf("invalid -channel %q: use lowercase letters, digits and dashes", o.channel)
+		}
+	}
+	if o.allowDowngrade && o.manualVer == "" {
+		return errors.New("-allow-downgrade only applies to -version")
+	}
+	o.mirrors = nil
+	for _, d := range o.remoteDirs {
+		m, err := parseMirror(d)
+		if err != nil {
+			return fmt.Errorf("invalid -remote-dir: %v", err)
+		}
+		if m.host != "" && o.backend == "local" {
+			return fmt.Errorf("-remote-dir %s names a host, which -backend local cannot reach", d)
+		}
+		o.mirrors = append(o.mirrors, m)
+	}
+	if o.notesFile != "" {
+		if len(modes) > 0 && modes[0] != "-append-to" && modes[0] != "-validate-only" {
+			return fmt.Errorf("-notes-file cannot be combined with %s", modes[0])
+		}
+		if _, err := os.Stat(o.notesFile); err != nil {
+			return fmt.Errorf("invalid -notes-file: %v", err)
+		}
+	}
+	if o.checksumEnc != release.EncodingHex && o.checksumEnc != release.EncodingSRI {
+		return fmt.Errorf("invalid -checksum-encoding %q (want hex or sri)", o.checksumEnc)
+	}
+	if o.sshAlias != "" {
+		set := map[string]bool{}
+		flag.Visit(func(f *flag.Flag) { set[f.Name] = true })
+		if set["host"] {
+			return errors.New("-ssh-alias replaces -host; give only one")
+		}
+		// ssh reads the host, port and user from its config
+		o.hostPort = o.sshAlias
+		if !set["user"] {
+			o.user = ""
+		}
+	}
+	for _, b := range o.baseURLs {
+		if strings.Contains(b, "{host}") && o.sshAlias == "" {
+			return fmt.Errorf("-base-url %q uses {host}, which needs -ssh-alias", b)
+		}
+	}
+	switch o.collision {
+	case "overwrite", "skip", "error":
+	default:
+		return fmt.Errorf("invalid -collision-policy %q (want overwrite, skip or error)", o.collision)
+	}
+	if o.expectedSums != "" {
+		if _, err := loadExpectedChecksums(o.expectedSums); err != nil {
+			return fmt.Errorf("invalid -expected-checksums: %v", err)
+		}
+	}
+	if o.mirrorJobs < 1 {
+		return fmt.Errorf("invalid -mirror-jobs %d (want 1 or more)", o.mirrorJobs)
+	}
+	if o.feedFormat != release.FeedRSS && o.feedFormat != release.FeedAtom {
+		return fmt.Errorf("invalid -feed-format %q (want rss or atom)", o.feedFormat)
+	}
+	for _, kv := range o.buildEnv {
+		if k, _, ok := strings.Cut(kv, "="); !ok || strings.TrimSpace(k) == "" {
+			return fmt.Errorf("invalid -build-env %q (want KEY=VALUE)", kv)
+		}
+	}
+	if o.buildRetries < 0 {
+		return fmt.Errorf("invalid -build-retries %d (want 0 or more)", o.buildRetries)
+	}
+	if o.jobs < 1 {
+		return fmt.Errorf("invalid -jobs %d (want 1 or more)", o.jobs)
+	}
+	for _, kv := range o.meta {
+		k, _, ok := strings.Cut(kv, "=")
+		if !ok || strings.TrimSpace(k) == "" {
+			return fmt.Errorf("invalid -manifest-meta %q: want KEY=VALUE with a non-empty key", kv)
+		}
+	}
+	if len(o.meta) > 0 && o.format != release.FormatWrapped {
+		return errors.New("-manifest-meta requires -manifest-format wrapped")
+	}
+	if o.resumeRelease && (o.dryRun || o.fromManifest != "") {
+		return errors.New("-resume-release cannot be combined with -dry-run or -from-manifest")
+	}
+	for _, kv := range o.channelMap {
+		target, ch, ok := strings.Cut(kv, "=")
+		if !ok || target == "" || !validChannel(ch) {
+			return fmt.Errorf("invalid -channel-map %q: want TARGET=CHANNEL, with a channel of lowercase letters, digits and dashes", kv)
+		}
+	}
+	for _, v := range o.minOS {
+		target, ver, ok := strings.Cut(v, "=")
+		if !ok {
+			target, ver = "", v
+		}
+		if ok && strings.TrimSpace(target) == "" || strings.TrimSpace(ver) == "" || strings.ContainsAny(ver, " \t") {
+			return fmt.Errorf("invalid -min-os-version %q (want [TARGET=]VERSION)", v)
+		}
+	}
+	if o.gzipManifest && o.noManifest {
+		return errors.New("-gzip-manifest cannot be combined with -no-manifest-upload")
+	}
+	switch o.linkFailure {
+	case "fail", "warn", "fallback-copy":
+	default:
+		return fmt.Errorf("invalid -symlink-failure-mode %q (want fail, warn or fallback-copy)", o.linkFailure)
+	}
+	if o.maxHistory < 0 {
+		return fmt.Errorf("invalid -max-history %d (want 0 or more)", o.maxHistory)
+	}
+	if o.fullHistory != "" {
+		if o.maxHistory == 0 {
+			return errors.New("-full-history-file needs -max-history")
+		}
+		if filepath.Clean(o.fullHistory) == filepath.Clean(o.jsonName) {
+			return errors.New("-full-history-file must differ from -json")
+		}
+	}
+	// the trimmed manifest does not list the older versions, whose files
+	// would all look orphaned
+	if o.gc && o.maxHistory > 0 && o.fullHistory == "" {
+		return errors.New("-gc with -max-history needs -full-history-file to know every version")
+	}
+	if o.chgrp != "" && strings.ContainsAny(o.chgrp, " \t\n/:") {
+		return fmt.Errorf("invalid -remote-chgrp %q", o.chgrp)
+	}
+	if o.keepGoing {
+		o.collectErrors = true
+	}
+	if o.artifactsFrom != "" && (o.srcArchive != "" || o.fromManifest != "") {
+		return errors.New("-artifacts-from cannot be combined with -src-archive or -from-manifest")
+	}
+	if o.replaceArtifact != "" {
+		if o.replaceFile == "" {
+			return errors.New("-replace-artifact wants a version and a file name: -replace-artifact 1.2.3 client-1.2.3-linux.zip")
+		}
+		if o.replaceFile != filepath.Base(o.replaceFile) || strings.ContainsAny(o.replaceFile, `/\`) {
+			return fmt.Errorf("invalid -replace-artifact file %q: want the file name as the manifest lists it", o.replaceFile)
+		}
+		// the others publish what a whole release built, or nothing
+		for _, c := range []struct {
+			name string
+			set  bool
+		}{
+			{"resume-release", o.resumeRelease},
+			{"allow-empty", o.allowEmpty},
+			{"dedupe-storage", o.dedupe},
+			{"no-manifest-upload", o.noManifest},
+		} {
+			if c.set {
+				return fmt.Errorf("-replace-artifact cannot be combined with -%s", c.name)
+			}
+		}
+	}
+	if o.acceptNewKeys && o.strictKeys {
+		return errors.New("-accept-new-host-keys and -strict-host-keys cannot be combined")
+	}
+	if (o.acceptNewKeys || o.strictKeys || o.knownHosts != "") && o.backend != "ssh" {
+		return errors.New("-accept-new-host-keys, -strict-host-keys and -known-hosts-file only apply to -backend ssh")
+	}
+	if o.knownHosts != "" {
+		// ssh splits option values at spaces, and rsync splits -e too
+		if strings.ContainsAny(o.knownHosts, " \t\"'") {
+			return fmt.Errorf("invalid -known-hosts-file %q: the path must not contain spaces or quotes", o.knownHosts)
+		}
+		// a missing file is created by accept-new; otherwise no host is known
+		if _, err := os.Stat(o.knownHosts); err != nil && !o.acceptNewKeys {
+			return fmt.Errorf("invalid -known-hosts-file: %v", err)
+		}
+	}
+	if o.verifyURLs && len(o.baseURLs) == 0 {
+		return errors.New("-verify-urls requires -base-url")
+	}
+	if o.quiet && o.outputJSON {
+		return errors.New("-quiet and -output-json cannot be combined")
+	}
+	if o.maxClockSkew < 0 {
+		return errors.New("-max-clock-skew cannot be negative")
+	}
+	if o.dryRunRemote && o.dryRun {
+		return errors.New("-dry-run-remote connects to the server and cannot be combined with -dry-run")
+	}
+	if o.streamUpload {
+		if len(modes) > 0 {
+			return fmt.Errorf("-stream-upload cannot be combined with %s", modes[0])
+		}
+		// each of these needs the artifacts on disk or the upload undone
+		for _, c := range []struct {
+			name string
+			set  bool
+		}{
+			{"dry-run", o.dryRun},
+			{"resume-release", o.resumeRelease},
+			{"src-archive", o.srcArchive != ""},
+			{"validate-archives", o.validateZips},
+			{"content-hash", o.contentHash},
+			{"dedupe-storage", o.dedupe},
+			{"allow-empty", o.allowEmpty},
+		} {
+			if c.set {
+				return fmt.Errorf("-stream-upload cannot be combined with -%s", c.name)
+			}
+		}
+		if len(o.remoteDirs) > 1 {
+			return errors.New("-stream-upload sends each file once and supports a single -remote-dir")
+		}
+		if o.bundleUpload {
+			return errors.New("-stream-upload cannot be combined with -bundle-upload")
+		}
+	}
+	return nil
+}
+
+func main() {
+	opts := parseFlags()
+	if err := opts.validate(); err != nil {
+		fmt.Fprintln(os.Stderr, "error:", err)
+		flag.Usage()
+		os.Exit(exitUsage)
+	}
+	if opts.printConfig {
+		if err := printConfig(os.Stdout); err != nil {
+			fmt.Fprintln(os.Stderr, "error:", err)
+			os.Exit(exitFailure)
+		}
+		return
+	}
+
+	// Ctrl-C / SIGTERM and -timeout stop whatever command is running
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	if opts.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, opts.timeout)
+		defer cancel()
+	}
+
+	err := run(ctx, opts)
+	switch {
+	case err == nil:
+		return
+	case errors.Is(err, errUpdateAvailable), errors.Is(err, errNoChanges):
+		// already reported; only the exit status differs
+	case errors.Is(err, context.Canceled):
+		fmt.Fprintln(os.Stderr, "release cancelled")
+	case errors.Is(err, context.DeadlineExceeded):
+		fmt.Fprintf(os.Stderr, "release timed out after %s\n", opts.timeout)
+	default:
+		fmt.Fprintln(os.Stderr, err)
+	}
+	stop()
+	os.Exit(exitCode(err))
+}
+
+// Exit codes; see the README for the contract.
+const (
+	exitFailure  = 1
+	exitUsage    = 2
+	exitBuild    = 3
+	exitUpload   = 4
+	exitChecksum = 5
+	exitManifest = 6
+	// exitUpdateAvailable is -check-update finding a newer release
+	exitUpdateAvailable = 10
+	// exitNoChanges is a run that left the published manifest as it was
+	exitNoChanges = 11
+	exitCancelled = 130
 )
 
-const (
-	dlDir = "downloads"
-)
+// errNoChanges is returned when a run changed nothing that clients see;
+// like errUpdateAvailable it only selects an exit status.
+var errNoChanges = errors.New("no changes")
+
+// exitCode maps an error from run to the process exit status.
+func exitCode(err error) int {
+	var (
+		buildErr    *release.BuildError
+		uploadErr   *release.UploadError
+		checksumErr *release.ChecksumError
+		manifestErr *release.ManifestError
+	)
+	switch {
+	case errors.Is(err, errUpdateAvailable):
+		return exitUpdateAvailable
+	case errors.Is(err, errNoChanges):
+		return exitNoChanges
+	case errors.Is(err, context.Canceled):
+		return exitCancelled
+	case errors.As(err, &buildErr):
+		return exitBuild
+	case errors.As(err, &uploadErr):
+		return exitUpload
+	case errors.As(err, &checksumErr):
+		return exitChecksum
+	case errors.As(err, &manifestErr):
+		return exitManifest
+	}
+	return exitFailure
+}
+
+func run(ctx context.Context, opts *options) error {
+	keys, _ := release.ParseChecksumKey(opts.checksumKey)
+	opts.manifest = release.ManifestOptions{
+		Indent:           strings.Repeat(" ", opts.jsonIndent),
+		ChecksumKeys:     keys,
+		ChecksumEncoding: opts.checksumEnc,
+		Generator:        &release.GeneratorInfo{Name: "relayUpdater", Version: version},
+	}
+	if opts.format == release.FormatWrapped {
+		if err := loadMeta(opts); err != nil {
+			return err
+		}
+	}
+	if opts.sshAlias != "" {
+		resolveAliasURLs(ctx, opts)
+	}
+	metrics := &releaseMetrics{start: time.Now()}
+
+	if opts.validateOnly {
+		return validateOnly(ctx, opts)
+	}
+	if opts.fetchURL != "" {
+		return fetchRelease(ctx, opts)
+	}
+	if opts.checkURL != "" {
+		return checkUpdate(ctx, opts)
+	}
+	if opts.migrate {
+		return migrateManifest(opts)
+	}
+
+	// ensure local release-dir exists
+	if err := os.MkdirAll(dlDir, 0755); err != nil {
+		return fmt.Errorf("failed to create release-dir: %w", err)
+	}
+
+	// load or initialize JSON
+	entries, err := release.ReadEntries(manifestSource(opts), opts.format)
+	if err != nil {
+		return &release.ManifestError{Err: fmt.Errorf("failed to read JSON: %w", err)}
+	}
+
+	var remote release.Transport
+	if opts.recording() {
+		script, err := os.OpenFile(opts.dryRunScript, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0755)
+		if err != nil {
+			return fmt.Errorf("failed to create dry-run script: %w", err)
+		}
+		defer script.Close()
+		if err := release.WriteScriptHeader(script); err != nil {
+			return err
+		}
+		if remote, err = newScriptTransport(opts, script); err != nil {
+			return err
+		}
+	} else if remote, err = newTransport(opts, nil); err != nil {
+		return err
+	}
+
+	if opts.dryRunRemote {
+		return checkRemoteAccess(ctx, opts, remote)
+	}
+
+	// a 10-minute build is wasted if the upload cannot even connect
+	if !opts.dryRun && !opts.skipPreflight && opts.backend == "ssh" {
+		for _, ho := range opts.hostOptions() {
+			ssh, err := newSSHTransport(ho)
+			if err != nil {
+				return err
+			}
+			if err := ssh.Ping(ctx); err != nil {
+				return &release.UploadError{Err: fmt.Errorf("preflight check of %s failed: %w", ho.hostPort, err)}
+			}
+		}
+	}
+
+	if opts.gc {
+		for _, m := range opts.mirrors {
+			t, err := mirrorRemote(opts, remote, nil, m)
+			if err == nil {
+				err = collectGarbage(ctx, t, m.dir, opts.jsonName, entries, opts.gcDelete)
+			}
+			if err != nil {
+				return fmt.Errorf("gc of %s failed: %w", m, err)
+			}
+		}
+		return nil
+	}
+	if opts.listRemote {
+		var errs []error
+		for _, m := range opts.mirrors {
+			t, err := mirrorRemote(opts, remote, nil, m)
+			if err == nil {
+				err = listRemote(ctx, t, m.dir, opts.jsonName, entries, opts.sinceTime)
+			}
+			if err != nil {
+				errs = append(errs, err)
+			}
+		}
+		return errors.Join(errs...)
+	}
+
+	warnBadVersions(entries, opts.jsonName)
+
+	// a recording cannot read the server, so only real runs check
+	if opts.noClobber && !opts.force && !opts.noManifest && !opts.dryRun {
+		for _, m := range opts.mirrors {
+			t, err := mirrorRemote(opts, remote, nil, m)
+			if err != nil {
+				return err
+			}
+			if err := checkNoClobber(ctx, t, m.dir, opts, entries); err != nil {
+				return err
+			}
+		}
+	}
+
+	// entry dates come from this clock; a recording has no server to ask
+	if opts.maxClockSkew > 0 && !opts.dryRun {
+		checkClockSkew(ctx, remote, opts.maxClockSkew)
+	}
+
+	if opts.touch != "" {
+		return touchRelease(ctx, opts, remote, entries)
+	}
+	if opts.setRollout != "" {
+		return setRollout(ctx, opts, remote, entries)
+	}
+	if opts.setLatest != "" {
+		return setLatest(ctx, opts, remote, entries)
+	}
+
+	// -from-manifest and -src-archive do not build from -src-dir
+	if opts.cleanTree && !opts.allowDirty && opts.fromManifest == "" && opts.srcArchive == "" {
+		if err := checkCleanTree(ctx, opts.srcDir); err != nil {
+			return err
+		}
+	}
+
+	if opts.replaceArtifact != "" {
+		return replaceArtifact(ctx, opts, remote, entries)
+	}
+
+	if opts.dryRun && opts.gpgKey != "" {
+		if err := checkSigning(ctx, opts.gpgKey); err != nil {
+			return err
+		}
+		fmt.Printf("gpg key %s can sign\n", opts.gpgKey)
+	}
+
+	var state *releaseState
+	var newVersion string
+	// -from-manifest and resumed runs publish what an earlier run recorded
+	changed := true
+	if opts.resumeRelease {
+		if state, newVersion, err = resumeState(opts); err != nil {
+			return err
+		}
+	} else if newVersion, err = pickVersion(opts, entries); err != nil {
+		return err
+	} else if !opts.dryRun && opts.fromManifest == "" && !opts.streamUpload {
+		// a streamed release cannot be resumed; its files are not kept
+		state = &releaseState{path: filepath.Join(dlDir, stateFileName), Manifest: opts.jsonName, Version: newVersion}
+	}
+	versionDir := filepath.Join(dlDir, newVersion)
+
+	var files []string
+	// with -allow-empty and nothing built, the version it reuses
+	var carried string
+	if opts.fromManifest != "" {
+		// already built and recorded: just make sure the files are intact
+		if files, err = verifyLocalArtifacts(entries[release.FindEntry(entries, newVersion)]); err != nil {
+			return err
+		}
+	} else if opts.streamUpload {
+		var links []release.DownloadInfo
+		if files, links, err = streamArtifacts(ctx, opts, remote, newVersion, metrics); err != nil {
+			return err
+		}
+		applyChannels(opts, links)
+		applyMinOS(opts, links)
+		if err := crossCheck(opts, links, newVersion); err != nil {
+			return err
+		}
+		var missing []string
+		if opts.allowPartial {
+			missing = missingTargets(files, splitList(opts.targets))
+		}
+		if entries, changed, err = saveEntry(opts, entries, newVersion, links, missing); err != nil {
+			return err
+		}
+	} else {
+		if state.done(stepBuild) {
+			if len(state.Files) == 0 && opts.allowEmpty {
+				if carried, err = emptyRelease(entries, newVersion, versionDir); err != nil {
+					return err
+				}
+			} else if err := state.checkBuilt(ctx, versionDir, opts.jobs); err != nil {
+				return fmt.Errorf("cannot resume the build step: %w", err)
+			}
+			files = state.Files
+			fmt.Printf("resuming: %d file(s) already built\n", len(files))
+		} else {
+			files, err = buildArtifacts(ctx, opts, newVersion, versionDir, metrics)
+			if opts.allowEmpty && errors.Is(err, errNoArtifacts) {
+				if carried, err = emptyRelease(entries, newVersion, versionDir); err != nil {
+					return err
+				}
+				fmt.Printf("no .zip files found; %s keeps the files of %s\n", newVersion, carried)
+			} else if err != nil {
+				return err
+			}
+			if err := state.markBuilt(files); err != nil {
+				return err
+			}
+		}
+
+		var links []release.DownloadInfo
+		if state.done(stepChecksum) {
+			if err := state.checkSummed(); err != nil {
+				return &release.ChecksumError{Err: fmt.Errorf("cannot resume the checksum step: %w", err)}
+			}
+			links = state.Links
+			fmt.Println("resuming: checksums already taken")
+		} else if carried != "" {
+			// the old entry's links, pointing at its files in downloads/
+			links = slices.Clone(entries[release.FindEntry(entries, carried)].Links)
+			if err := state.markSummed(links); err != nil {
+				return err
+			}
+		} else {
+			if links, err = checksumArtifacts(opts, versionDir, files, metrics); err != nil {
+				return err
+			}
+			applyChannels(opts, links)
+			applyMinOS(opts, links)
+			if err := crossCheck(opts, links, newVersion); err != nil {
+				return err
+			}
+			// after the cross-check, which is about the bytes CI built
+			if opts.contentHash && opts.dedupe {
+				if err := reuseSameContent(entries, newVersion, links); err != nil {
+					return err
+				}
+			}
+			if err := state.markSummed(links); err != nil {
+				return err
+			}
+		}
+
+		if state.done(stepManifest) {
+			if err := state.checkSaved(entries); err != nil {
+				return &release.ManifestError{Err: fmt.Errorf("cannot resume the manifest step: %w", err)}
+			}
+			fmt.Printf("resuming: %s already updated\n", opts.jsonName)
+		} else {
+			var missing []string
+			if opts.allowPartial && carried == "" {
+				missing = missingTargets(files, splitList(opts.targets))
+			}
+			if entries, changed, err = saveEntry(opts, entries, newVersion, links, missing); err != nil {
+				return err
+			}
+			if err := state.mark(stepManifest); err != nil {
+				return err
+			}
+		}
+	}
+
+	// extras are uploaded with the artifacts but get no -latest link
+	var extras []string
+	if opts.streamUpload && (opts.sumsFile || opts.uploadNotes) {
+		// nothing else of the release is kept locally
+		if err := os.MkdirAll(versionDir, 0755); err != nil {
+			return fmt.Errorf("failed to create version dir: %w", err)
+		}
+	}
+	if opts.sumsFile {
+		// cover the whole entry, not just what -append-to added
+		links := entries[release.FindEntry(entries, newVersion)].Links
+		if extras, err = writeSignedSums(ctx, versionDir, opts.gpgKey, links); err != nil {
+			return err
+		}
+	}
+	if notes := entries[release.FindEntry(entries, newVersion)].Notes; opts.uploadNotes && notes != "" {
+		if err := os.WriteFile(filepath.Join(versionDir, notesFileName), []byte(notes+"\n"), 0644); err != nil {
+			return fmt.Errorf("failed to write release notes: %w", err)
+		}
+		extras = append(extras, notesFileName)
+	}
+
+	if !changed {
+		// the manifest already lists these files, so a run before this
+		// one published them
+		fmt.Printf("%s is unchanged; nothing to upload\n", opts.jsonName)
+	} else if err := publish(ctx, opts, remote, state, entries, newVersion, versionDir, files, extras, metrics); err != nil {
+		if state != nil {
+			fmt.Fprintln(os.Stderr, "rerun with -resume-release to continue from the failed step")
+		}
+		return err
+	}
+	if err := state.clear(); err != nil {
+		fmt.Fprintln(os.Stderr, "warning: failed to remove release state:", err)
+	}
+	// the release is complete; a failure here is for the web server's admin
+	if opts.verifyURLs && !opts.dryRun {
+		if err := verifyURLs(ctx, opts, entries[release.FindEntry(entries, newVersion)].Links); err != nil {
+			return err
+		}
+	}
+
+	if opts.metricsFile != "" {
+		if err := metrics.write(opts.metricsFile, newVersion); err != nil {
+			fmt.Fprintln(os.Stderr, "warning: failed to write metrics:", err)
+		}
+	}
+
+	if !changed {
+		fmt.Printf("✅ No changes: version %s was already released with these files\n", newVersion)
+		return errNoChanges
+	}
+	if !opts.quiet {
+		if err := printSummary(os.Stdout, entries[release.FindEntry(entries, newVersion)], opts.outputJSON); err != nil {
+			return err
+		}
+	}
+	if carried != "" {
+		fmt.Printf("✅ Released version %s with the files of %s\n", newVersion, carried)
+		return nil
+	}
+	if opts.streamUpload {
+		fmt.Printf("✅ Released version %s to %s with %d file(s)\n",
+			newVersion, remoteVersionPath(opts.mirrors[0].String(), newVersion), len(files))
+		return nil
+	}
+	fmt.Printf("✅ Released version %s in %s with %d file(s)\n",
+		newVersion, versionDir, len(files))
+	return nil
+}
+
+// emptyRelease returns the release below newVersion whose files an
+// -allow-empty release reuses, and creates versionDir for any extras.
+func emptyRelease(entries []release.Entry, newVersion, versionDir string) (string, error) {
+	nv, err := semver.NewVersion(newVersion)
+	if err != nil {
+		return "", err
+	}
+	var prev *semver.Version
+	var from string
+	for _, e := range entries {
+		v, err := semver.NewVersion(strings.TrimSpace(e.Version))
+		if err != nil || !v.LessThan(nv) || len(e.Links) == 0 {
+			continue
+		}
+		if prev == nil || v.GreaterThan(prev) {
+			prev, from = v, e.Version
+		}
+	}
+	if from == "" {
+		return "", &release.BuildError{Err: fmt.Errorf("-allow-empty: no .zip files found and no release before %s to take files from", newVersion)}
+	}
+	if err := os.MkdirAll(versionDir, 0755); err != nil {
+		return "", fmt.Errorf("failed to create version dir: %w", err)
+	}
+	return from, nil
+}
+
+// loadMeta keeps the manifest's existing "meta" object and applies the
+// -manifest-meta changes on top.
+func loadMeta(opts *options) error {
+	meta, err := release.ReadMeta(opts.jsonName)
+	if err != nil {
+		return &release.ManifestError{Err: fmt.Errorf("failed to read meta from %s: %w", opts.jsonName, err)}
+	}
+	if meta == nil {
+		meta = map[string]string{}
+	}
+	for _, kv := range opts.meta {
+		k, v, _ := strings.Cut(kv, "=")
+		if k = strings.TrimSpace(k); v == "" {
+			delete(meta, k)
+		} else {
+			meta[k] = v
+		}
+	}
+	opts.manifest.Meta = meta
+	return nil
+}
+
+// resolveAliasURLs fills {host} in the -base-url values with the real host
+// name behind -ssh-alias, or with the alias itself if ssh cannot say.
+func resolveAliasURLs(ctx context.Context, opts *options) {
+	host, _, err := release.ResolveSSHAlias(ctx, opts.sshCommand, opts.sshAlias)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "warning: could not resolve -ssh-alias %s, using it as the host name: %v\n", opts.sshAlias, err)
+		host = opts.sshAlias
+	}
+	for i, b := range opts.baseURLs {
+		opts.baseURLs[i] = strings.ReplaceAll(b, "{host}", host)
+	}
+}
+
+// newSSHTransport builds the ssh/scp transport from the flags.
+func newSSHTransport(opts *options) (*release.SSHTransport, error) {
+	ssh, err := release.NewSSHTransport(opts.hostPort, opts.user, opts.jumpHost)
+	if err != nil {
+		return nil, fmt.Errorf("invalid remote host: %w", err)
+	}
+	ssh.SSHCommand = opts.sshCommand
+	ssh.SCPCommand = opts.scpCommand
+	ssh.Options = hostKeyOptions(opts)
+	ssh.RelativeSymlinks = opts.linkStyle == "relative"
+	return ssh, nil
+}
+
+// hostKeyOptions returns the ssh options the host key flags ask for. With
+// none of them, ssh_config and ssh's own default decide, which prompts for
+// unknown hosts.
+func hostKeyOptions(opts *options) []string {
+	var o []string
+	switch {
+	case opts.acceptNewKeys:
+		o = append(o, "StrictHostKeyChecking=accept-new")
+	case opts.strictKeys:
+		o = append(o, "StrictHostKeyChecking=yes")
+	}
+	if opts.knownHosts != "" {
+		o = append(o, "UserKnownHostsFile="+opts.knownHosts)
+	}
+	return o
+}
+
+// newTransport builds the Transport selected by -transport.
+func newTransport(opts *options, out io.Writer) (release.Transport, error) {
+	var t release.Transport
+	if opts.backend == "local" {
+		t = &release.LocalTransport{Stdout: out, Stderr: out, RelativeSymlinks: opts.linkStyle == "relative"}
+	} else {
+		ssh, err := newSSHTransport(opts)
+		if err != nil {
+			return nil, err
+		}
+		if out != nil {
+			ssh.Stdout, ssh.Stderr = out, out
+		} else {
+			out = os.Stderr
+		}
+		t = uploadTransport(opts, ssh, out)
+	}
+	if opts.remoteTmpDir != "" {
+		t = &release.StagedTransport{Transport: t, TmpDir: opts.remoteTmpDir}
+	}
+	return t, nil
+}
+
+// newScriptTransport returns a transport that records to w the commands
+// newTransport's would run, uploading with -transport and staging through
+// -remote-tmp-dir like it.
+func newScriptTransport(opts *options, w io.Writer) (*release.ScriptTransport, error) {
+	ssh, err := newSSHTransport(opts)
+	if err != nil {
+		return nil, err
+	}
+	return &release.ScriptTransport{SSHTransport: ssh, W: w, Uploader: uploadTransport(opts, ssh, os.Stderr), TmpDir: opts.remoteTmpDir}, nil
+}
+
+// uploadTransport wraps ssh in the uploader -transport selects.
+func uploadTransport(opts *options, ssh *release.SSHTransport, out io.Writer) release.Transport {
+	if opts.transport == "rsync" {
+		if _, err := exec.LookPath("rsync"); err != nil {
+			fmt.Fprintln(out, "warning: rsync not found in PATH, uploading with scp instead")
+			return ssh
+		}
+		return &release.RsyncTransport{SSHTransport: ssh}
+	}
+	if opts.transport == "sftp" {
+		return &release.SFTPTransport{SSHTransport: ssh, SFTPCommand: opts.sftpCommand}
+	}
+	return ssh
+}
+
+// touchRelease sets the date of an existing entry to now and uploads the
+// manifest. Artifacts are not rebuilt, re-hashed or re-uploaded.
+func touchRelease(ctx context.Context, opts *options, remote release.Transport, entries []release.Entry) error {
+	i := release.FindEntry(entries, opts.touch)
+	if i < 0 {
+		return &release.ManifestError{Err: fmt.Errorf("-touch: version %s is not in %s", opts.touch, opts.jsonName)}
+	}
+	entries[i].Date = time.Now().UTC().UnixNano()
+	if err := rewriteManifest(ctx, opts, remote, entries); err != nil {
+		return err
+	}
+	fmt.Printf("✅ Touched version %s\n", entries[i].Version)
+	return nil
+}
+
+// setRollout changes the rollout percentage of an existing entry and
+// uploads the manifest, e.g. to finish a staged rollout at 100.
+func setRollout(ctx context.Context, opts *options, remote release.Transport, entries []release.Entry) error {
+	i := release.FindEntry(entries, opts.setRollout)
+	if i < 0 {
+		return &release.ManifestError{Err: fmt.Errorf("-set-rollout: version %s is not in %s", opts.setRollout, opts.jsonName)}
+	}
+	pct := opts.rolloutPct
+	entries[i].RolloutPercent = &pct
+	if err := rewriteManifest(ctx, opts, remote, entries); err != nil {
+		return err
+	}
+	if opts.dryRun {
+		fmt.Printf("would roll out version %s to %d%%\n", entries[i].Version, pct)
+		return nil
+	}
+	fmt.Printf("✅ Version %s now rolls out to %d%%\n", entries[i].Version, pct)
+	return nil
+}
+
+// setLatest points the -latest aliases under every -remote-dir at the
+// files of an existing entry, after checking they are on the server. The
+// manifest is neither changed nor uploaded; the next release of a higher
+// version moves the aliases on as usual.
+func setLatest(ctx context.Context, opts *options, remote release.Transport, entries []release.Entry) error {
+	i := release.FindEntry(entries, opts.setLatest)
+	if i < 0 {
+		return &release.ManifestError{Err: fmt.Errorf("-set-latest: version %s is not in %s", opts.setLatest, opts.jsonName)}
+	}
+	version := entries[i].Version
+	links := ownLinks(entries[i].Links, version)
+	if len(links) == 0 {
+		return &release.ManifestError{Err: fmt.Errorf("-set-latest: version %s has no files of its own; use the version whose files it lists", version)}
+	}
+	var files []string
+	channels := map[string]string{}
+	for _, l := range links {
+		f := filepath.Base(l.Link)
+		files = append(files, f)
+		channels[f] = l.Channel
+	}
+
+	if opts.dryRun && !opts.recording() {
+		for _, f := range files {
+			target, link := release.LatestLinkPathsIn(dlDir, version, f, channels[f])
+			fmt.Printf("would point %s at %s\n", link, target)
+		}
+		return nil
+	}
+	for _, m := range opts.mirrors {
+		remote, err := mirrorRemote(opts, remote, nil, m)
+		if err != nil {
+			return err
+		}
+		dir := m.dir
+		var paths []string
+		for _, f := range files {
+			paths = append(paths, remoteVersionPath(dir, version)+"/"+f)
+		}
+		if err := checkUploaded(ctx, remote, paths); err != nil {
+			return &release.UploadError{Err: fmt.Errorf("-set-latest: version %s is not on %s: %w", version, m, err)}
+		}
+		tool := opts.remoteTool
+		if tool == "auto" && opts.latestMode == "copy" && !opts.recording() {
+			var err error
+			if tool, err = detectChecksumTool(ctx, remote); err != nil {
+				return &release.UploadError{Err: err}
+			}
+		}
+		if err := pointLatest(ctx, opts, remote, os.Stderr, dir, tool, version, files, channels); err != nil {
+			if len(opts.mirrors) > 1 {
+				return fmt.Errorf("%s: %w", m, err)
+			}
+			return err
+		}
+	}
+	if opts.recordLatest && entries[i].Latest == nil {
+		fmt.Fprintf(os.Stderr, "warning: %s still records the -latest aliases under another version\n", opts.jsonName)
+	}
+	fmt.Printf("✅ The -latest aliases now point at version %s\n", version)
+	return nil
+}
+
+// rewriteManifest writes entries locally and uploads the manifest alone. It
+// returns errNoChanges if the manifest stayed the same.
+func rewriteManifest(ctx context.Context, opts *options, remote release.Transport, entries []release.Entry) error {
+	changed, err := writeManifest(opts, entries, false)
+	if err != nil {
+		return err
+	}
+	if !changed {
+		fmt.Printf("%s is unchanged; nothing to upload\n", opts.jsonName)
+		return errNoChanges
+	}
+	if !opts.dryRun || opts.recording() {
+		manifests, err := manifestFiles(opts)
+		if err != nil {
+			return err
+		}
+		for _, m := range opts.mirrors {
+			remote, err := mirrorRemote(opts, remote, nil, m)
+			if err != nil {
+				return err
+			}
+			dir := m.dir
+			if err := remote.Upload(ctx, dir, manifests...); err != nil {
+				return &release.UploadError{Err: fmt.Errorf("upload JSON to %s failed: %w", m, err)}
+			}
+			if opts.chgrp != "" {
+				if err := remoteChgrp(ctx, remote, opts.chgrp, false, remoteManifests(dir, manifests)...); err != nil {
+					return &release.UploadError{Err: err}
+				}
+			}
+			if err := linkManifest(ctx, opts, remote, dir); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// writeManifest writes entries to the local manifest, or only appends the
+// last entry if appendLine is set, and reports whether the content changed.
+// With -diff or -dry-run the change is printed first, and a dry run that
+// is not recorded stops there.
+func writeManifest(opts *options, entries []release.Entry, appendLine bool) (bool, error) {
+	if opts.maxHistory > 0 {
+		if opts.fullHistory != "" && !(opts.dryRun && !opts.recording()) {
+			if err := release.WriteEntries(opts.fullHistory, opts.format, entries, opts.manifest); err != nil {
+				return false, &release.ManifestError{Err: fmt.Errorf("failed to write -full-history-file: %w", err)}
+			}
+		}
+		if newest := release.NewestEntries(entries, opts.maxHistory); len(newest) < len(entries) {
+			entries = newest
+			appendLine = false
+		}
+	}
+	if appendLine {
+		// only the new line is encoded and shown, so the cost does not
+		// grow with the history; an appended line is always a change
+		line, err := release.EncodeEntries(release.FormatJSONL, entries[len(entries)-1:], opts.manifest)
+		if err != nil {
+			return false, &release.ManifestError{Err: fmt.Errorf("failed to encode JSON: %w", err)}
+		}
+		if opts.diff || opts.dryRun {
+			fmt.Print(appendDiff(opts.jsonName, opts.jsonName+" (proposed)", line))
+		}
+		if opts.dryRun && !opts.recording() {
+			fmt.Printf("dry run: %s not written\n", opts.jsonName)
+			return true, nil
+		}
+		if err := release.AppendEntryLine(opts.jsonName, entries[len(entries)-1], opts.manifest); err != nil {
+			return false, &release.ManifestError{Err: fmt.Errorf("failed to write JSON: %w", err)}
+		}
+		return true, nil
+	}
+	proposed, err := release.EncodeEntries(opts.format, entries, opts.manifest)
+	if err != nil {
+		return false, &release.ManifestError{Err: fmt.Errorf("failed to encode JSON: %w", err)}
+	}
+	current, err := os.ReadFile(opts.jsonName)
+	if err != nil && !os.IsNotExist(err) {
+		return false, &release.ManifestError{Err: fmt.Errorf("failed to read JSON: %w", err)}
+	}
+	changed := !bytes.Equal(current, proposed)
+	if opts.diff || opts.dryRun {
+		if d := unifiedDiff(opts.jsonName, opts.jsonName+" (proposed)", current, proposed); d != "" {
+			fmt.Print(d)
+		} else {
+			fmt.Printf("%s is unchanged\n", opts.jsonName)
+		}
+	}
+	if opts.dryRun && !opts.recording() {
+		fmt.Printf("dry run: %s not written\n", opts.jsonName)
+		return changed, nil
+	}
+	if err := release.WriteEntries(opts.jsonName, opts.format, entries, opts.manifest); err != nil {
+		return false, &release.ManifestError{Err: fmt.Errorf("failed to write JSON: %w", err)}
+	}
+	return changed, nil
+}
+
+// manifestFiles writes the files derived from the manifest, a gzipped
+// copy for -gzip-manifest and the -feed-file, and returns manifestNames.
+func manifestFiles(opts *options) ([]string, error) {
+	if opts.gzipManifest {
+		if _, err := release.WriteGzip(opts.jsonName); err != nil {
+			return nil, &release.ManifestError{Err: fmt.Errorf("failed to gzip JSON: %w", err)}
+		}
+	}
+	if opts.feedFile != "" {
+		// the feed follows what is served, so it is read back from -json
+		entries, err := release.ReadEntries(opts.jsonName, opts.format)
+		if err != nil {
+			return nil, &release.ManifestError{Err: fmt.Errorf("failed to read JSON: %w", err)}
+		}
+		if !opts.sinceTime.IsZero() {
+			entries = release.EntriesSince(entries, opts.sinceTime)
+		}
+		title := strings.TrimSuffix(filepath.Base(opts.jsonName), filepath.Ext(opts.jsonName)) + " releases"
+		var base string
+		if len(opts.baseURLs) > 0 {
+			base = opts.baseURLs[0]
+		}
+		if err := release.WriteFeed(opts.feedFile, opts.feedFormat, release.Feed{Title: title, BaseURL: base}, entries); err != nil {
+			return nil, &release.ManifestError{Err: fmt.Errorf("failed to write -feed-file: %w", err)}
+		}
+	}
+	return manifestNames(opts), nil
+}
+
+// manifestNames lists the local files uploaded next to the manifest,
+// starting with the manifest itself.
+func manifestNames(opts *options) []string {
+	names := []string{opts.jsonName}
+	if opts.gzipManifest {
+		names = append(names, opts.jsonName+".gz")
+	}
+	if opts.feedFile != "" {
+		names = append(names, opts.feedFile)
+	}
+	return names
+}
+
+// manifestSource is the file the manifest is read from: the
+// -full-history-file once it exists, since -json may be trimmed.
+func manifestSource(opts *options) string {
+	if opts.fullHistory != "" {
+		if _, err := os.Stat(opts.fullHistory); err == nil {
+			return opts.fullHistory
+		}
+	}
+	return opts.jsonName
+}
+
+// validateOnly goes through build, collect, archive validation and
+// checksums in a temporary directory and prints what a release would
+// contain. Neither the manifest nor the remote side is touched.
+func validateOnly(ctx context.Context, opts *options) error {
+	entries := []release.Entry{}
+	if _, err := os.Stat(manifestSource(opts)); err == nil {
+		var err error
+		if entries, err = release.ReadEntries(manifestSource(opts), opts.format); err != nil {
+			return &release.ManifestError{Err: fmt.Errorf("failed to read JSON: %w", err)}
+		}
+	}
+	warnBadVersions(entries, opts.jsonName)
+	newVersion, err := pickVersion(opts, entries)
+	if err != nil {
+		return err
+	}
+
+	tmp, err := os.MkdirTemp("", "relayUpdater-validate-")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(tmp)
+
+	files, err := buildArtifacts(ctx, opts, newVersion, tmp, &releaseMetrics{})
+	if err != nil {
+		return err
+	}
+	fmt.Printf("version %s would be released with %d file(s):\n", newVersion, len(files))
+	var links []release.DownloadInfo
+	for _, f := range files {
+		sum, err := release.ComputeChecksum(filepath.Join(tmp, f))
+		if err != nil {
+			return &release.ChecksumError{Err: fmt.Errorf("checksum failed for %s: %w", f, err)}
+		}
+		fmt.Printf("  %s  %s\n", sum, filepath.Join(dlDir, newVersion, f))
+		links = append(links, release.DownloadInfo{Link: f, Checksum: sum})
+	}
+	if err := crossCheck(opts, links, newVersion); err != nil {
+		return err
+	}
+	fmt.Println("✅ validation passed; nothing was written or uploaded")
+	return nil
+}
+
+// readVersionFile returns the version in path, which must hold a single
+// semver version and nothing else but whitespace.
+func readVersionFile(path string) (string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", fmt.Errorf("failed to read -version-file: %w", err)
+	}
+	v := strings.TrimSpace(string(data))
+	if v == "" {
+		return "", fmt.Errorf("-version-file %s is empty", path)
+	}
+	if _, err := semver.NewVersion(v); err != nil {
+		return "", fmt.Errorf("-version-file %s: %q is not a valid version: %v", path, v, err)
+	}
+	return v, nil
+}
+
+// pickVersion decides which version this run releases.
+func pickVersion(opts *options, entries []release.Entry) (string, error) {
+	for _, f := range []struct{ name, val string }{
+		{"append-to", opts.appendTo},
+		{"from-manifest", opts.fromManifest},
+	} {
+		if f.val == "" {
+			continue
+		}
+		v, err := semver.NewVersion(f.val)
+		if err != nil {
+			return "", fmt.Errorf("invalid -%s %q: %v", f.name, f.val, err)
+		}
+		i := release.FindEntry(entries, v.String())
+		if i < 0 {
+			return "", &release.ManifestError{Err: fmt.Errorf("-%s: version %s is not in %s", f.name, v, opts.jsonName)}
+		}
+		// keep the stored spelling so existing filenames still match
+		return strings.TrimSpace(entries[i].Version), nil
+	}
+	if opts.manualVer != "" {
+		v, err := semver.NewVersion(opts.manualVer)
+		if err != nil {
+			return "", fmt.Errorf("invalid -version %q: %v", opts.manualVer, err)
+		}
+		// a fat-fingered lower version would confuse clients
+		if hasValidVersion(entries) && !opts.allowDowngrade {
+			if highest := release.HighestVersion(entries); !v.GreaterThan(highest) {
+				return "", fmt.Errorf("-version %s is not newer than the current highest %s; pass -allow-downgrade to release it anyway", v, highest)
+			}
+		}
+		return opts.verPrefix + v.String(), nil
+	}
+	if !hasValidVersion(entries) {
+		v, err := semver.NewVersion(opts.initialVer)
+		if err != nil {
+			return "", fmt.Errorf("invalid -initial-version %q: %v", opts.initialVer, err)
+		}
+		fmt.Fprintf(os.Stderr, "%s has no releases yet; using initial version %s (set -initial-version or -version to choose another)\n",
+			opts.jsonName, opts.verPrefix+v.String())
+		return opts.verPrefix + v.String(), nil
+	}
+	return opts.verPrefix + release.HighestVersion(entries).IncPatch().String(), nil
+}
+
+// buildArtifacts runs the build and copies its zips into versionDir,
+// returning their new file names.
+func buildArtifacts(ctx context.Context, opts *options, newVersion, versionDir string, metrics *releaseMetrics) ([]string, error) {
+	targets := splitList(opts.targets)
+	srcDir := opts.srcDir
+	if opts.srcArchive != "" {
+		// CI already built everything; unpack its bundle instead
+		tmp, err := os.MkdirTemp("", "relay-src-")
+		if err != nil {
+			return nil, fmt.Errorf("failed to create temp dir: %w", err)
+		}
+		defer os.RemoveAll(tmp)
+		n, err := extractInnerZips(ctx, opts.srcArchive, tmp)
+		if err != nil {
+			return nil, &release.BuildError{Err: fmt.Errorf("invalid -src-archive: %w", err)}
+		}
+		if n == 0 {
+			return nil, &release.BuildError{Err: fmt.Errorf("%w inside %s", errNoArtifacts, opts.srcArchive)}
+		}
+		srcDir = tmp
+	} else if !opts.skipBuild {
+		if err := runBuild(ctx, opts, newVersion, targets); err != nil {
+			// some targets may still have built; collecting decides
+			if !opts.allowPartial || ctx.Err() != nil {
+				return nil, &release.BuildError{Err: fmt.Errorf("Build process failed: %w", err)}
+			}
+			fmt.Fprintln(os.Stderr, "warning: build failed, releasing the targets that were built:", err)
+		}
+	}
+
+	// create version subfolder
+	if err := os.MkdirAll(versionDir, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create version dir: %w", err)
+	}
+
+	// copy & rename zips into releases/<version>/
+	zips, err := sourceZips(opts, srcDir, newVersion, targets)
+	if err != nil {
+		return nil, &release.BuildError{Err: fmt.Errorf("error handling zip files: %w", err)}
+	}
+	files, err := collectAndRenameZips(zips, versionDir, opts.collision)
+	if err != nil {
+		return nil, &release.BuildError{Err: fmt.Errorf("error handling zip files: %w", err)}
+	}
+	if opts.allowPartial {
+		missing := missingTargets(files, targets)
+		fmt.Printf("targets included: %s\n", strings.Join(subtract(targets, missing), ", "))
+		if len(missing) > 0 {
+			fmt.Printf("targets missing:  %s\n", strings.Join(missing, ", "))
+		}
+	}
+	if opts.validateZips || opts.validateOnly {
+		if err := validateArchives(ctx, versionDir, files, opts.jobs); err != nil {
+			return nil, &release.BuildError{Err: fmt.Errorf("invalid archive: %w", err)}
+		}
+	}
+	return files, nil
+}
+
+// checksumArtifacts hashes each file in versionDir into a manifest link.
+func checksumArtifacts(opts *options, versionDir string, files []string, metrics *releaseMetrics) ([]release.DownloadInfo, error) {
+	var cache *release.ChecksumCache
+	if !opts.noCache {
+		var err error
+		if cache, err = release.LoadChecksumCache(release.ChecksumCacheFile); err != nil {
+			return nil, fmt.Errorf("failed to read checksum cache: %w", err)
+		}
+	}
+
+	// build JSON entries using only filenames
+	checksumStart := time.Now()
+	var links []release.DownloadInfo
+	var failed []error
+	for _, file := range files {
+		link, err := checksumArtifact(opts, cache, filepath.Join(versionDir, file))
+		if err != nil {
+			if !opts.collectErrors {
+				return nil, &release.ChecksumError{Err: err}
+			}
+			failed = append(failed, err)
+			continue
+		}
+		links = append(links, link)
+	}
+	metrics.checksumTime = time.Since(checksumStart)
+	metrics.artifacts = len(links)
+	if err := cache.Save(); err != nil {
+		fmt.Fprintln(os.Stderr, "warning: failed to save checksum cache:", err)
+	}
+	if len(failed) > 0 {
+		return nil, &release.ChecksumError{Err: fmt.Errorf("%d of %d artifact(s) failed:\n%w", len(failed), len(files), errors.Join(failed...))}
+	}
+	return links, nil
+}
+
+// checksumArtifact hashes one artifact into a manifest link.
+func checksumArtifact(opts *options, cache *release.ChecksumCache, fullPath string) (release.DownloadInfo, error) {
+	sum, err := cache.Checksum(fullPath)
+	if err != nil {
+		return release.DownloadInfo{}, fmt.Errorf("checksum failed for %s: %w", fullPath, err)
+	}
+
+	fi, err := os.Stat(fullPath)
+	if err != nil {
+		return release.DownloadInfo{}, err
+	}
+	link := release.DownloadInfo{
+		Link:     fullPath,
+		Checksum: sum,
+		Mirrors:  release.MirrorURLs(opts.baseURLs, fullPath),
+		Size:     fi.Size(),
+	}
+	if opts.contentHash {
+		if link.ContentHash, err = release.ContentChecksum(fullPath); err != nil {
+			return release.DownloadInfo{}, fmt.Errorf("content checksum failed for %s: %w", fullPath, err)
+		}
+	}
+	return link, nil
+}
+
+// saveEntry records links for newVersion in the manifest and writes it,
+// reporting whether the manifest changed.
+func saveEntry(opts *options, entries []release.Entry, newVersion string, links []release.DownloadInfo, missing []string) ([]release.Entry, bool, error) {
+	// append entry & write JSON
+	existed := release.FindEntry(entries, newVersion) >= 0
+	if opts.appendTo != "" {
+		entries = release.MergeEntry(entries, release.Entry{Version: newVersion, Links: links})
+		// targets appended now are no longer missing
+		e := &entries[release.FindEntry(entries, newVersion)]
+		var names []string
+		for _, l := range e.Links {
+			names = append(names, filepath.Base(l.Link))
+		}
+		still := missingTargets(names, e.Missing)
+		for _, t := range missing {
+			if !slices.Contains(still, t) {
+				still = append(still, t)
+			}
+		}
+		e.Missing = still
+	} else {
+		e := release.Entry{
+			Version: newVersion,
+			Date:    time.Now().UTC().UnixNano(),
+			Links:   links,
+			Missing: missing,
+		}
+		if opts.rollout < 100 {
+			pct := opts.rollout
+			e.RolloutPercent = &pct
+		}
+		entries = release.UpsertEntry(entries, e)
+	}
+	if opts.notesFile != "" {
+		notes, err := os.ReadFile(opts.notesFile)
+		if err != nil {
+			return nil, false, fmt.Errorf("failed to read -notes-file: %w", err)
+		}
+		entries[release.FindEntry(entries, newVersion)].Notes = strings.TrimSpace(string(notes))
+	}
+	if opts.analyticsURL != "" {
+		entries[release.FindEntry(entries, newVersion)].AnalyticsURL = opts.analyticsURL
+	}
+	if opts.dirChecksum {
+		e := &entries[release.FindEntry(entries, newVersion)]
+		e.DirChecksum = release.DirChecksum(e.Links)
+	}
+	if opts.recordLatest && release.IsHighest(entries, newVersion) {
+		entries = release.RecordLatestAliases(entries, newVersion, dlDir)
+		// other entries lose their aliases, so the whole file changes
+		existed = true
+	}
+	// a brand new version only needs its own line appended
+	appendLine := opts.format == release.FormatJSONL && !existed
+	changed, err := writeManifest(opts, entries, appendLine)
+	if err != nil {
+		return nil, false, err
+	}
+	return entries, changed, nil
+}
+
+// crossCheck compares links with -expected-checksums, if given.
+func crossCheck(opts *options, links []release.DownloadInfo, version string) error {
+	if opts.expectedSums == "" {
+		return nil
+	}
+	expected, err := loadExpectedChecksums(opts.expectedSums)
+	if err != nil {
+		return &release.ChecksumError{Err: fmt.Errorf("failed to read -expected-checksums: %w", err)}
+	}
+	if err := checkExpectedChecksums(expected, links, version); err != nil {
+		return &release.ChecksumError{Err: err}
+	}
+	return nil
+}
+
+// verifyLocalArtifacts checks that every file recorded in e exists locally
+// with the recorded checksum and returns the file names.
+func verifyLocalArtifacts(e release.Entry) ([]string, error) {
+	var files []string
+	for _, l := range e.Links {
+		sum, err := release.ComputeChecksum(l.Link)
+		if err != nil {
+			return nil, &release.ChecksumError{Err: fmt.Errorf("checksum failed for %s: %w", l.Link, err)}
+		}
+		if sum != l.Checksum {
+			return nil, &release.ChecksumError{Err: fmt.Errorf("checksum mismatch for %s: have %s, manifest has %s", l.Link, sum, l.Checksum)}
+		}
+		files = append(files, filepath.Base(l.Link))
+	}
+	if len(files) == 0 {
+		return nil, &release.ManifestError{Err: fmt.Errorf("version %s has no artifacts in the manifest", e.Version)}
+	}
+	return files, nil
+}
+
+// publish runs publishTo for each -remote-dir. With one job they run in
+// order and the first failure stops the run: earlier directories are
+// complete, later ones untouched. With -mirror-jobs above 1 they run
+// concurrently, each with its own transport and buffered output, which is
+// printed in -remote-dir order under a label; every mirror is tried and
+// all failures are reported together.
+func publish(ctx context.Context, opts *options, remote release.Transport, state *releaseState, entries []release.Entry, newVersion, versionDir string, files, extras []string, metrics *releaseMetrics) error {
+	if !opts.noManifest && !(opts.dryRun && !opts.recording()) {
+		if _, err := manifestFiles(opts); err != nil {
+			return err
+		}
+	}
 
-type downloadInfo struct {
-	Link     string `json:"link"`
-	Checksum string `json:"sha256"`
-}
+	// a recorded script must list its commands in order
+	if opts.mirrorJobs == 1 || len(opts.mirrors) == 1 || opts.recording() {
+		var failed []error
+		for _, m := range opts.mirrors {
+			t, err := mirrorRemote(opts, remote, nil, m)
+			if err == nil {
+				err = publishTo(ctx, opts, t, state, os.Stderr, m, entries, newVersion, versionDir, files, extras, metrics)
+			}
+			if err == nil {
+				continue
+			}
+			if len(opts.mirrors) == 1 {
+				return err
+			}
+			if !opts.keepGoing || ctx.Err() != nil {
+				return fmt.Errorf("%s: %w", m, err)
+			}
+			fmt.Fprintf(os.Stderr, "[%s] failed: %v\n", m, err)
+			failed = append(failed, fmt.Errorf("%s: %w", m, err))
+		}
+		return mirrorFailures(opts, failed)
+	}
+
+	logs := make([]bytes.Buffer, len(opts.mirrors))
+	errs := make([]error, len(opts.mirrors))
+	sem := make(chan struct{}, opts.mirrorJobs)
+	var wg sync.WaitGroup
+	for i, m := range opts.mirrors {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			sem <- struct{}{}
+			defer func() { <-sem }()
+			t, err := newTransport(opts.forMirror(m), &logs[i])
+			if err == nil {
+				err = publishTo(ctx, opts, t, state, &logs[i], m, entries, newVersion, versionDir, files, extras, metrics)
+			}
+			errs[i] = err
+		}()
+	}
+	wg.Wait()
 
-type Entry struct {
-	Version string         `json:"version"`
-	Date    int64          `json:"utc-unixnano"`
-	Links   []downloadInfo `json:"links"`
+	var failed []error
+	for i, dir := range opts.mirrors {
+		for _, line := range strings.Split(strings.TrimRight(logs[i].String(), "\n"), "\n") {
+			if line != "" {
+				fmt.Fprintf(os.Stderr, "[%s] %s\n", dir, line)
+			}
+		}
+		if errs[i] != nil {
+			fmt.Fprintf(os.Stderr, "[%s] failed: %v\n", dir, errs[i])
+			failed = append(failed, fmt.Errorf("%s: %w", dir, errs[i]))
+		}
+	}
+	return mirrorFailures(opts, failed)
 }
 
-func main() {
-	dryRun := flag.Bool("dry-run", false, "do not upload via ssh (testing)")
-	srcDir := flag.String("src-dir", "../RelayClient", "directory to scan for .zip files")
-	manualVer := flag.String("version", "", "manually specify new version (format a.b.c)")
-	hostPort := flag.String("host", "host.ext", "SSH host[:port]")
-	user := flag.String("user", "user", "SSH username")
-	remoteDir := flag.String("remote-dir", "/home/user/www/public_html", "remote directory")
-	jsonName := flag.String("json", "relayClient.json", "name of JSON file")
-	flag.Parse()
+// mirrorFailures sums up a publish that went on after mirrors failed.
+func mirrorFailures(opts *options, failed []error) error {
+	if len(failed) == 0 {
+		return nil
+	}
+	n := len(opts.mirrors)
+	fmt.Fprintf(os.Stderr, "published to %d of %d mirror(s)\n", n-len(failed), n)
+	return fmt.Errorf("%d of %d mirror(s) failed: %w", len(failed), n, errors.Join(failed...))
+}
 
-	// ensure local release-dir exists
-	if err := os.MkdirAll(dlDir, 0755); err != nil {
-		fmt.Fprintln(os.Stderr, "failed to create release-dir:", err)
-		os.Exit(1)
+// publishTo uploads files and the manifest under the mirror's directory and
+// points the -latest links at newVersion if it is the newest release. Steps
+// state records as done are checked and skipped.
+func publishTo(ctx context.Context, opts *options, remote release.Transport, state *releaseState, log io.Writer, m mirror, entries []release.Entry, newVersion, versionDir string, files, extras []string, metrics *releaseMetrics) error {
+	remoteDir := m.dir
+	remoteVersionDir := remoteVersionPath(remoteDir, newVersion)
+	uploaded, linked := stepUpload+" "+m.String(), stepLatest+" "+m.String()
+	if state.done(linked) {
+		fmt.Fprintf(log, "resuming: %s is already published\n", m)
+		return nil
 	}
 
-	// load or initialize JSON
-	entries, err := readEntries(*jsonName)
-	if err != nil {
-		fmt.Fprintln(os.Stderr, "failed to read JSON:", err)
-		os.Exit(1)
+	// hashing on the server needs output, which a recording cannot give
+	tool := opts.remoteTool
+	needTool := opts.verifyRemote && opts.verifyMode == "tool" || opts.latestMode == "copy"
+	if tool == "auto" && needTool && !opts.dryRun {
+		var err error
+		if tool, err = detectChecksumTool(ctx, remote); err != nil {
+			return &release.UploadError{Err: err}
+		}
 	}
 
-	// pick new version
-	var newVersion string
-	if *manualVer != "" {
-		v, err := semver.NewVersion(*manualVer)
-		if err != nil {
-			fmt.Fprintf(os.Stderr, "invalid -version %q: %v\n", *manualVer, err)
-			os.Exit(1)
+	if state.done(uploaded) {
+		var paths []string
+		for _, f := range append(append([]string{}, files...), extras...) {
+			paths = append(paths, remoteVersionDir+"/"+f)
+		}
+		if !opts.noManifest {
+			paths = append(paths, remoteManifests(remoteDir, manifestNames(opts))...)
 		}
-		newVersion = v.String()
+		if err := checkUploaded(ctx, remote, paths); err != nil {
+			return &release.UploadError{Err: fmt.Errorf("cannot resume the upload step: %w", err)}
+		}
+		fmt.Fprintf(log, "resuming: files already uploaded to %s\n", m)
 	} else {
-		highest := semver.MustParse("0.0.0")
-		for _, e := range entries {
-			if v, err := semver.NewVersion(e.Version); err == nil && v.GreaterThan(highest) {
-				highest = v
-			}
+		if err := uploadTo(ctx, opts, remote, log, remoteDir, remoteVersionDir, tool, entries, newVersion, versionDir, files, extras, metrics); err != nil {
+			return err
+		}
+		if opts.dryRun && !opts.recording() {
+			return nil
+		}
+		if err := state.mark(uploaded); err != nil {
+			return err
 		}
-		newVersion = highest.IncPatch().String()
 	}
 
-	err = RunBuildAll(newVersion)
-	if err != nil {
-		log.Fatalf("Build process failed: %v", err)
+	if err := updateLatest(ctx, opts, remote, log, remoteDir, tool, entries, newVersion, files); err != nil {
+		return err
 	}
+	return state.mark(linked)
+}
 
-	// create version subfolder
-	versionDir := filepath.Join(dlDir, newVersion)
-	if err := os.MkdirAll(versionDir, 0755); err != nil {
-		fmt.Fprintln(os.Stderr, "failed to create version dir:", err)
-		os.Exit(1)
+// uploadTo uploads the artifacts, extras and manifest of newVersion.
+func uploadTo(ctx context.Context, opts *options, remote release.Transport, log io.Writer, remoteDir, remoteVersionDir, tool string, entries []release.Entry, newVersion, versionDir string, files, extras []string, metrics *releaseMetrics) error {
+	// ensure remote version folder exists; a plain dry run must not
+	// reach the server at all
+	if !(opts.dryRun && !opts.recording()) {
+		if err := remote.MkdirAll(ctx, remoteVersionDir); err != nil {
+			return &release.UploadError{Err: fmt.Errorf("failed to mkdir on remote: %w", err)}
+		}
 	}
 
-	// copy & rename zips into releases/<version>/
-	files, err := collectAndRenameZips(*srcDir, versionDir, newVersion)
-	if err != nil {
-		fmt.Fprintln(os.Stderr, "error handling zip files:", err)
-		os.Exit(1)
+	// artifacts an older version already has are linked, not uploaded;
+	// a recording cannot probe the server, so its script uploads them all
+	var dupes map[string]string
+	if opts.dedupe && !opts.dryRun {
+		dupes = map[string]string{}
+		for f, candidates := range duplicateArtifacts(entries, newVersion, remoteDir) {
+			// older files may have been removed by hand; use the first left
+			for _, target := range candidates {
+				if err := remote.Run(ctx, "test -f "+release.ShellQuote(target)); err == nil {
+					dupes[f] = target
+					break
+				}
+				if ctx.Err() != nil {
+					return ctx.Err()
+				}
+			}
+		}
 	}
 
-	// build JSON entries using only filenames
-	var links []downloadInfo
-	for _, file := range files {
-
-		fullPath := filepath.Join(versionDir, file)
+	// scp zips into remote/<version>/
+	uploads := append(append([]string{}, files...), extras...)
+	if opts.streamUpload {
+		// streamArtifacts has sent the artifacts already
+		uploads = extras
+	}
+	var localZips []string
+	for _, f := range uploads {
+		if _, ok := dupes[f]; ok {
+			continue
+		}
+		localZips = append(localZips, filepath.Join(versionDir, f))
+	}
+	// a recorded dry run goes through every step; nothing is executed
+	if opts.dryRun && !opts.recording() {
+		return nil
+	}
 
-		sum, err := computeChecksum(fullPath)
-		if err != nil {
-			fmt.Fprintf(os.Stderr, "checksum failed for %s: %v\n", fullPath, err)
-			os.Exit(1)
+	if len(localZips) > 0 && opts.bundleUpload {
+		// one transfer, so there is nothing for -collect-errors to collect
+		if err := uploadBundle(ctx, remote, remoteVersionDir, localZips, metrics); err != nil {
+			return &release.UploadError{Err: err}
+		}
+	} else if len(localZips) > 0 && opts.collectErrors {
+		// one at a time, so each failure is known and the rest still go
+		var failed []error
+		for _, z := range localZips {
+			if err := remote.Upload(ctx, remoteVersionDir, z); err != nil {
+				if ctx.Err() != nil {
+					return ctx.Err()
+				}
+				failed = append(failed, fmt.Errorf("%s: %w", filepath.Base(z), err))
+				continue
+			}
+			metrics.addUploaded(z)
+		}
+		if len(failed) > 0 {
+			fmt.Fprintf(log, "uploaded %d of %d file(s)\n", len(localZips)-len(failed), len(localZips))
+			return &release.UploadError{Err: fmt.Errorf("upload of %d of %d file(s) failed:\n%w", len(failed), len(localZips), errors.Join(failed...))}
+		}
+	} else if len(localZips) > 0 {
+		if err := remote.Upload(ctx, remoteVersionDir, localZips...); err != nil {
+			return &release.UploadError{Err: fmt.Errorf("upload zips failed: %w", err)}
+		}
+		metrics.addUploaded(localZips...)
+	}
+	for _, f := range files {
+		if target, ok := dupes[f]; ok {
+			if err := remote.Symlink(ctx, target, remoteVersionDir+"/"+f); err != nil {
+				return &release.UploadError{Err: fmt.Errorf("linking duplicate %s: %w", f, err)}
+			}
+			fmt.Fprintf(log, "%s is identical to %s; linked instead of uploaded\n", f, target)
 		}
+	}
 
-		links = append(links, downloadInfo{Link: fullPath, Checksum: sum})
+	// a listing is cheap, so it runs before any hashing
+	if (opts.verifyListing || opts.verifyRemote) && !opts.recording() {
+		links := ownLinks(entries[release.FindEntry(entries, newVersion)].Links, newVersion)
+		if err := verifyRemoteListing(ctx, remote, remoteVersionDir, links, extras); err != nil {
+			return &release.UploadError{Err: err}
+		}
+	}
+	if opts.verifyRemote && !opts.recording() {
+		links := ownLinks(entries[release.FindEntry(entries, newVersion)].Links, newVersion)
+		verifyTool := tool
+		if opts.verifyMode == "stream" {
+			verifyTool = ""
+		}
+		if err := verifyRemoteArtifacts(ctx, remote, verifyTool, remoteVersionDir, links); err != nil {
+			return &release.ChecksumError{Err: err}
+		}
+	}
 
+	// clients must be able to read the files before the manifest names them
+	if opts.chgrp != "" {
+		if err := remoteChgrp(ctx, remote, opts.chgrp, true, remoteVersionDir); err != nil {
+			return &release.UploadError{Err: err}
+		}
+	}
+	// with -no-manifest the manifest is managed elsewhere; the local copy
+	// is for reference
+	if !opts.noManifest {
+		manifests := manifestNames(opts)
+		if err := remote.Upload(ctx, remoteDir, manifests...); err != nil {
+			return &release.UploadError{Err: fmt.Errorf("upload JSON failed: %w", err)}
+		}
+		metrics.addUploaded(manifests...)
+		if opts.chgrp != "" {
+			if err := remoteChgrp(ctx, remote, opts.chgrp, false, remoteManifests(remoteDir, manifests)...); err != nil {
+				return &release.UploadError{Err: err}
+			}
+		}
+		if err := linkManifest(ctx, opts, remote, remoteDir); err != nil {
+			return err
+		}
 	}
+	return nil
+}
 
-	// append entry & write JSON
-	entries = upsertEntry(entries, Entry{
-		Version: newVersion,
-		Date:    time.Now().UTC().UnixNano(),
-		Links:   links,
+// ownLinks drops the links of an -allow-empty release that point at an
+// older version's files, which were checked when that version was released.
+func ownLinks(links []release.DownloadInfo, version string) []release.DownloadInfo {
+	dir := filepath.Join(dlDir, version)
+	return slices.DeleteFunc(slices.Clone(links), func(l release.DownloadInfo) bool {
+		return filepath.Dir(l.Link) != dir
 	})
-	if err := writeEntries(*jsonName, entries); err != nil {
-		fmt.Fprintln(os.Stderr, "failed to write JSON:", err)
-		os.Exit(1)
+}
+
+// remoteManifests returns where the local manifest files end up under
+// remoteDir.
+func remoteManifests(remoteDir string, manifests []string) []string {
+	out := make([]string, len(manifests))
+	for i, m := range manifests {
+		out[i] = path.Join(remoteDir, filepath.Base(m))
 	}
+	return out
+}
 
-	// ensure remote version folder exists
-	remoteVersionDir := strings.TrimRight(*remoteDir, "/") + "/" + dlDir + "/" + newVersion
-	if err := ensureRemoteDir(*hostPort, *user, remoteVersionDir); err != nil {
-		fmt.Fprintln(os.Stderr, "failed to mkdir on remote:", err)
-		os.Exit(1)
+// linkManifest points -manifest-alias in remoteDir at the uploaded
+// manifest. A copy or hard link must be remade on every upload; a symlink
+// is remade too, which costs nothing.
+func linkManifest(ctx context.Context, opts *options, remote release.Transport, remoteDir string) error {
+	if opts.manifestAlias == "" {
+		return nil
+	}
+	target := remoteManifests(remoteDir, []string{opts.jsonName})[0]
+	link := path.Join(remoteDir, opts.manifestAlias)
+	tmp := release.ShellQuote(link + ".tmp")
+	var err error
+	switch opts.latestMode {
+	case "copy":
+		err = remote.Run(ctx, "cp -f "+release.ShellQuote(target)+" "+tmp+" && mv -f "+tmp+" "+release.ShellQuote(link))
+	case "hardlink":
+		err = remote.Run(ctx, "ln -f "+release.ShellQuote(target)+" "+tmp+" && mv -f "+tmp+" "+release.ShellQuote(link))
+	default:
+		err = remote.Symlink(ctx, target, link)
+	}
+	if err != nil {
+		return &release.UploadError{Err: fmt.Errorf("pointing %s at %s failed: %w", link, target, err)}
 	}
+	return nil
+}
 
-	// scp zips into remote/<version>/
-	var localZips []string
-	for _, f := range files {
-		localZips = append(localZips, filepath.Join(versionDir, f))
+// updateLatest points the -latest aliases under remoteDir at newVersion if
+// it is the newest release.
+func updateLatest(ctx context.Context, opts *options, remote release.Transport, log io.Writer, remoteDir, tool string, entries []release.Entry, newVersion string, files []string) error {
+	// an older version gaining artifacts must not steal the latest links
+	if !release.IsHighest(entries, newVersion) {
+		return nil
+	}
+	// an -allow-empty release reuses the files the links point at already
+	if len(files) == 0 {
+		fmt.Fprintln(log, "no new files; the -latest aliases are left as they are")
+		return nil
+	}
+	channels := map[string]string{}
+	for _, l := range entries[release.FindEntry(entries, newVersion)].Links {
+		channels[filepath.Base(l.Link)] = l.Channel
 	}
-	if !*dryRun {
+	return pointLatest(ctx, opts, remote, log, remoteDir, tool, newVersion, files, channels)
+}
 
-		if err := uploadWithScp(*hostPort, *user, remoteVersionDir, localZips...); err != nil {
-			fmt.Fprintln(os.Stderr, "upload zips failed:", err)
-			os.Exit(1)
+// pointLatest makes the -latest aliases of files under remoteDir point at
+// version in -latest-mode and checks them, honouring
+// -symlink-failure-mode.
+func pointLatest(ctx context.Context, opts *options, remote release.Transport, log io.Writer, remoteDir, tool, newVersion string, files []string, channels map[string]string) error {
+	if opts.latestMode == "copy" {
+		if err := updateAliases(ctx, opts, remote, log, updateLatestFileCopies, remoteDir, newVersion, files, channels); err != nil {
+			return &release.UploadError{Err: fmt.Errorf("failed to update latest file copies: %w", err)}
+		}
+		if opts.recording() {
+			return nil
+		}
+		// a drifted copy would serve the wrong bytes, so this always fails
+		if err := verifyLatestCopies(ctx, remote, tool, remoteDownloads(remoteDir), newVersion, files, channels); err != nil {
+			return &release.UploadError{Err: err}
+		}
+		return nil
+	}
+	verifySymlinks := func(ctx context.Context, t release.Transport, remoteBase, version string, files []string, channels map[string]string) error {
+		return verifyLatestSymlinks(ctx, t, remoteBase, version, files, channels, opts.linkStyle == "relative")
+	}
+	update, verify, what := updateLatestFileSymlinks, verifySymlinks, "file‑symlinks"
+	if opts.latestMode == "hardlink" {
+		update, verify, what = updateLatestFileHardlinks, verifyLatestHardlinks, "hard links"
+	}
+	if err := updateAliases(ctx, opts, remote, log, update, remoteDir, newVersion, files, channels); err != nil {
+		err = fmt.Errorf("failed to update latest %s: %w", what, err)
+		switch opts.linkFailure {
+		case "warn":
+			fmt.Fprintf(log, "warning: %v\nthe upload is complete, but the -latest aliases were not updated\n", err)
+			return nil
+		case "fallback-copy":
+			fmt.Fprintf(log, "warning: %v\nfalling back to -latest-mode copy\n", err)
+			copyOpts := *opts
+			copyOpts.latestMode = "copy"
+			if tool == "auto" {
+				if tool, err = detectChecksumTool(ctx, remote); err != nil {
+					return &release.UploadError{Err: err}
+				}
+			}
+			return pointLatest(ctx, &copyOpts, remote, log, remoteDir, tool, newVersion, files, channels)
 		}
+		return &release.UploadError{Err: err}
+	}
+	if opts.verifyLinks != "off" && !opts.recording() {
+		if err := verify(ctx, remote, remoteDownloads(remoteDir), newVersion, files, channels); err != nil {
+			if opts.verifyLinks == "fail" {
+				return &release.UploadError{Err: err}
+			}
+			fmt.Fprintln(log, "warning:", err)
+		}
+	}
+	return nil
+}
 
-		if err := uploadWithScp(*hostPort, *user, *remoteDir, *jsonName); err != nil {
-			fmt.Fprintln(os.Stderr, "upload JSON failed:", err)
-			os.Exit(1)
+// updateAliases runs update for the -latest aliases of files. With
+// -keep-going it runs once per file, so one alias that cannot be made does
+// not stop the others, and the failures are reported together.
+func updateAliases(ctx context.Context, opts *options, remote release.Transport, log io.Writer, update func(context.Context, release.Transport, string, string, []string, map[string]string) error, remoteDir, newVersion string, files []string, channels map[string]string) error {
+	if !opts.keepGoing {
+		return update(ctx, remote, remoteDownloads(remoteDir), newVersion, files, channels)
+	}
+	var failed []error
+	for _, f := range files {
+		if err := update(ctx, remote, remoteDownloads(remoteDir), newVersion, []string{f}, channels); err != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			failed = append(failed, err)
 		}
+	}
+	if len(failed) > 0 {
+		fmt.Fprintf(log, "updated %d of %d -latest alias(es)\n", len(files)-len(failed), len(files))
+		return fmt.Errorf("%d of %d failed:\n%w", len(failed), len(files), errors.Join(failed...))
+	}
+	return nil
+}
 
-		if err := updateLatestFileSymlinks(*hostPort, *user, *remoteDir+"/"+dlDir, newVersion, files); err != nil {
-			fmt.Fprintln(os.Stderr, "failed to update latest file‑symlinks:", err)
-			os.Exit(1)
+// reuseSameContent replaces each new archive whose contents match an
+// older release's archive, but not its bytes, with a copy of that older
+// archive, so -dedupe-storage can link it instead of uploading it. The
+// older file must still be in downloads/ with its recorded checksum.
+func reuseSameContent(entries []release.Entry, newVersion string, links []release.DownloadInfo) error {
+	older := map[string]release.DownloadInfo{}
+	for _, e := range entries {
+		if release.SameVersion(e.Version, newVersion) {
+			continue
+		}
+		for _, l := range e.Links {
+			if l.ContentHash != "" {
+				older[l.ContentHash] = l
+			}
+		}
+	}
+	for i := range links {
+		l := &links[i]
+		old, ok := older[l.ContentHash]
+		if !ok || old.Checksum == l.Checksum {
+			continue
+		}
+		if sum, err := release.ComputeChecksum(old.Link); err != nil || sum != old.Checksum {
+			continue
+		}
+		if err := copyFile(old.Link, l.Link); err != nil {
+			return fmt.Errorf("failed to reuse %s: %w", old.Link, err)
+		}
+		fmt.Printf("%s has the same contents as %s; reusing that archive\n", filepath.Base(l.Link), old.Link)
+		fi, err := os.Stat(l.Link)
+		if err != nil {
+			return err
 		}
+		l.Checksum, l.Size = old.Checksum, fi.Size()
 	}
+	return nil
+}
 
-	fmt.Printf("✅ Released version %s in %s with %d file(s)\n",
-		newVersion, versionDir, len(files))
+// duplicateArtifacts maps each artifact of newVersion to the remote paths
+// of older files with the same checksum, newest first.
+func duplicateArtifacts(entries []release.Entry, newVersion, remoteDir string) map[string][]string {
+	bySum := map[string][]string{}
+	var current []release.DownloadInfo
+	for i := len(entries) - 1; i >= 0; i-- {
+		e := entries[i]
+		if release.SameVersion(e.Version, newVersion) {
+			current = e.Links
+			continue
+		}
+		for _, l := range e.Links {
+			if l.Checksum != "" {
+				bySum[l.Checksum] = append(bySum[l.Checksum], path.Join(remoteDir, filepath.ToSlash(l.Link)))
+			}
+		}
+	}
+	dupes := map[string][]string{}
+	for _, l := range current {
+		if targets, ok := bySum[l.Checksum]; ok {
+			dupes[filepath.Base(l.Link)] = targets
+		}
+	}
+	return dupes
 }
 
-func readEntries(path string) ([]Entry, error) {
-	data, err := os.ReadFile(path)
-	if err != nil {
-		if os.IsNotExist(err) {
-			if err := writeEntries(path, []Entry{}); err != nil {
-				return nil, err
+// errNoArtifacts is returned when the build left nothing to release.
+var errNoArtifacts = errors.New("no .zip files")
+
+// collectAndRenameZips copies zips (see sourceZips) into versionDir under
+// their versioned names. An existing file of the same name is handled by
+// policy: "overwrite", "skip" (keep it) or "error".
+func collectAndRenameZips(zips []sourceZip, versionDir, policy string) ([]string, error) {
+	var out []string
+	for _, z := range zips {
+		dst := filepath.Join(versionDir, z.name)
+		if _, err := os.Stat(dst); err == nil {
+			switch policy {
+			case "error":
+				return nil, fmt.Errorf("%s already exists (-collision-policy error)", dst)
+			case "skip":
+				fmt.Printf("%s already exists, keeping it\n", dst)
+				out = append(out, z.name)
+				continue
+			default:
+				fmt.Printf("%s already exists, overwriting it\n", dst)
 			}
-			return []Entry{}, nil
 		}
-		return nil, err
+		if err := copyFile(z.src, dst); err != nil {
+			return nil, err
+		}
+		out = append(out, z.name)
+	}
+	return out, nil
+}
+
+// sourceZips finds the zips to release: those -artifacts-from lists, or
+// else the ones in srcDir.
+func sourceZips(opts *options, srcDir, ver string, targets []string) ([]sourceZip, error) {
+	if opts.artifactsFrom == "" {
+		return findZips(srcDir, ver, targets, opts.allowPartial, opts.sanitizeNames)
 	}
-	var ents []Entry
-	if err := json.Unmarshal(data, &ents); err != nil {
+	paths, err := readArtifactList(opts.artifactsFrom)
+	if err != nil {
 		return nil, err
 	}
-	return ents, nil
+	where := opts.artifactsFrom
+	if where == "-" {
+		where = "the list on stdin"
+	}
+	return nameZips(paths, where, ver, targets, opts.allowPartial, opts.sanitizeNames)
 }
 
-func writeEntries(path string, ents []Entry) error {
-	out, err := json.MarshalIndent(ents, "", "  ")
+// readArtifactList reads one path per line from file, or stdin for "-".
+// Blank lines and lines starting with # are skipped. Every path must be an
+// existing regular .zip file; all that are not are reported together.
+func readArtifactList(file string) ([]string, error) {
+	var data []byte
+	var err error
+	if file == "-" {
+		data, err = io.ReadAll(os.Stdin)
+	} else {
+		data, err = os.ReadFile(file)
+	}
 	if err != nil {
-		return err
+		return nil, fmt.Errorf("failed to read -artifacts-from: %w", err)
+	}
+	var paths, bad []string
+	for _, line := range strings.Split(string(data), "\n") {
+		p := strings.TrimSpace(line)
+		if p == "" || strings.HasPrefix(p, "#") {
+			continue
+		}
+		fi, err := os.Stat(p)
+		switch {
+		case err != nil:
+			bad = append(bad, err.Error())
+		case !fi.Mode().IsRegular():
+			bad = append(bad, p+": not a regular file")
+		case !strings.EqualFold(filepath.Ext(p), ".zip"):
+			bad = append(bad, p+": not a .zip file")
+		default:
+			paths = append(paths, p)
+		}
 	}
-	return os.WriteFile(path, out, 0644)
+	if len(bad) > 0 {
+		return nil, fmt.Errorf("invalid -artifacts-from entries:\n  %s", strings.Join(bad, "\n  "))
+	}
+	return paths, nil
+}
+
+// sourceZip is a built zip and the versioned name it is released under.
+type sourceZip struct {
+	src  string
+	name string
 }
 
-func collectAndRenameZips(srcDir, versionDir, ver string) ([]string, error) {
+// findZips lists the .zip files in srcDir, only those of targets if any
+// are given, with their names for version ver. All targets must be found
+// unless partial is set, and at least one zip must be. Names that are not
+// URL-safe are warned about, or with sanitize changed (see urlSafeName).
+func findZips(srcDir, ver string, targets []string, partial, sanitize bool) ([]sourceZip, error) {
 	entries, err := os.ReadDir(srcDir)
 	if err != nil {
 		return nil, err
 	}
-	var out []string
+	var paths []string
 	for _, de := range entries {
-		if de.IsDir() {
-			continue
+		if !de.IsDir() && strings.EqualFold(filepath.Ext(de.Name()), ".zip") {
+			paths = append(paths, filepath.Join(srcDir, de.Name()))
+		}
+	}
+	return nameZips(paths, srcDir, ver, targets, partial, sanitize)
+}
+
+// nameZips is findZips for a given list of zips; where names their source
+// in errors.
+func nameZips(paths []string, where, ver string, targets []string, partial, sanitize bool) ([]sourceZip, error) {
+	found := map[string]bool{}
+	var out []sourceZip
+	for _, p := range paths {
+		name := filepath.Base(p)
+		base := strings.TrimSuffix(name, filepath.Ext(name))
+		if len(targets) > 0 {
+			t := matchTarget(base, targets)
+			if t == "" {
+				continue
+			}
+			found[t] = true
 		}
-		if strings.EqualFold(filepath.Ext(de.Name()), ".zip") {
-			base := strings.TrimSuffix(de.Name(), filepath.Ext(de.Name()))
-			newName := fmt.Sprintf("%s-%s.zip", base, ver)
-			if err := copyFile(filepath.Join(srcDir, de.Name()), filepath.Join(versionDir, newName)); err != nil {
-				return nil, err
+		if safe := urlSafeName(base); safe != base {
+			if !sanitize {
+				fmt.Fprintf(os.Stderr, "warning: %s is not URL-safe; clients must percent-encode its links (-sanitize-names renames it)\n", name)
+			} else {
+				fmt.Printf("renamed %q -> %s-%s.zip\n", name, safe, ver)
+				base = safe
 			}
-			out = append(out, newName)
 		}
+		out = append(out, sourceZip{
+			src:  p,
+			name: fmt.Sprintf("%s-%s.zip", base, ver),
+		})
+	}
+	seen := map[string]string{}
+	for _, z := range out {
+		if other, ok := seen[z.name]; ok {
+			return nil, fmt.Errorf("%s and %s would both be released as %s", filepath.Base(other), filepath.Base(z.src), z.name)
+		}
+		seen[z.name] = z.src
+	}
+	var missing []string
+	for _, t := range targets {
+		if !found[t] {
+			missing = append(missing, t)
+		}
+	}
+	if len(missing) > 0 && !partial {
+		return nil, fmt.Errorf("no .zip found in %s for target(s): %s", where, strings.Join(missing, ", "))
 	}
 	if len(out) == 0 {
-		return nil, errors.New("no .zip files found in " + srcDir)
+		return nil, fmt.Errorf("%w found in %s", errNoArtifacts, where)
 	}
 	return out, nil
 }
 
+// urlSafeName replaces every character of name outside the RFC 3986
+// unreserved set, and "+" (which semver build metadata uses), with "_", so
+// that the name can be used in a URL path as it is.
+func urlSafeName(name string) string {
+	return strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9':
+			return r
+		case strings.ContainsRune("-._~+", r):
+			return r
+		}
+		return '_'
+	}, name)
+}
+
+// applyChannels sets the channel of each link from -channel-map; links no
+// mapping matches stay stable.
+func applyChannels(opts *options, links []release.DownloadInfo) {
+	for _, kv := range opts.channelMap {
+		target, ch, _ := strings.Cut(kv, "=")
+		if ch == "stable" {
+			ch = ""
+		}
+		for i := range links {
+			if matchTarget(filepath.Base(links[i].Link), []string{target}) != "" {
+				links[i].Channel = ch
+			}
+		}
+	}
+}
+
+// applyMinOS sets the minimum OS version of each link from -min-os-version.
+// A value for a target beats one for all artifacts, whatever their order.
+func applyMinOS(opts *options, links []release.DownloadInfo) {
+	for _, scoped := range []bool{false, true} {
+		for _, v := range opts.minOS {
+			target, ver, ok := strings.Cut(v, "=")
+			if ok != scoped {
+				continue
+			}
+			for i := range links {
+				if !ok {
+					links[i].MinOSVersion = v
+				} else if matchTarget(filepath.Base(links[i].Link), []string{target}) != "" {
+					links[i].MinOSVersion = ver
+				}
+			}
+		}
+	}
+}
+
+// validChannel reports whether ch can be part of a file name.
+func validChannel(ch string) bool {
+	if ch == "" || strings.HasPrefix(ch, "-") {
+		return false
+	}
+	for _, r := range ch {
+		if !(r >= 'a' && r <= 'z' || r >= '0' && r <= '9' || r == '-') {
+			return false
+		}
+	}
+	return true
+}
+
+// matchTarget returns the target whose whole name appears in an artifact
+// name between "-", "_" or "." separators (case-insensitive), or "". A
+// target may itself contain separators, as linux-arm64 does; when several
+// match, the longest wins, so linux-arm64 is not taken for linux.
+func matchTarget(name string, targets []string) string {
+	lower := strings.ToLower(name)
+	var best string
+	for _, t := range targets {
+		if len(t) > len(best) && containsWord(lower, strings.ToLower(t)) {
+			best = t
+		}
+	}
+	return best
+}
+
+// containsWord reports whether word occurs in s with a separator or the
+// end of s on both sides.
+func containsWord(s, word string) bool {
+	if word == "" {
+		return false
+	}
+	sep := func(i int) bool { return i < 0 || i >= len(s) || strings.ContainsRune("-_.", rune(s[i])) }
+	for off := 0; ; {
+		i := strings.Index(s[off:], word)
+		if i < 0 {
+			return false
+		}
+		i += off
+		if sep(i-1) && sep(i+len(word)) {
+			return true
+		}
+		off = i + 1
+	}
+}
+
+// missingTargets returns the targets that none of files matches.
+func missingTargets(files, targets []string) []string {
+	found := map[string]bool{}
+	for _, f := range files {
+		found[matchTarget(strings.TrimSuffix(f, filepath.Ext(f)), targets)] = true
+	}
+	var missing []string
+	for _, t := range targets {
+		if !found[t] {
+			missing = append(missing, t)
+		}
+	}
+	return missing
+}
+
+// subtract returns the items of list that are not in drop.
+func subtract(list, drop []string) []string {
+	var out []string
+	for _, s := range list {
+		if !slices.Contains(drop, s) {
+			out = append(out, s)
+		}
+	}
+	return out
+}
+
+// splitList splits a comma-separated flag value, dropping empty items.
+func splitList(s string) []string {
+	var out []string
+	for _, item := range strings.Split(s, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			out = append(out, item)
+		}
+	}
+	return out
+}
+
 func copyFile(src, dst string) error {
 	sf, err := os.Open(src)
 	if err != nil {
@@ -221,109 +2747,143 @@ func copyFile(src, dst string) error {
 	if err != nil {
 		return err
 	}
-	defer df.Close()
-	_, err = io.Copy(df, sf)
-	return err
+	if _, err := io.Copy(df, sf); err != nil {
+		df.Close()
+		return err
+	}
+	if err := df.Close(); err != nil {
+		return err
+	}
+	// keep the source mtime so unchanged artifacts hit the checksum cache
+	return os.Chtimes(dst, fi.ModTime(), fi.ModTime())
 }
 
-func computeChecksum(path string) (string, error) {
-	f, err := os.Open(path)
-	if err != nil {
-		return "", err
+// warnBadVersions reports manifest entries whose version is blank or not
+// valid semver; they are ignored when looking for the highest version.
+func warnBadVersions(entries []release.Entry, jsonName string) {
+	for i, e := range entries {
+		if strings.TrimSpace(e.Version) == "" {
+			fmt.Fprintf(os.Stderr, "warning: %s entry %d has a blank version, ignoring it\n", jsonName, i)
+		} else if _, err := release.ParseVersion(e.Version); err != nil {
+			fmt.Fprintf(os.Stderr, "warning: %s entry %d has invalid version %q, ignoring it\n", jsonName, i, e.Version)
+		}
 	}
-	defer f.Close()
+}
 
-	h := sha256.New()
-	if _, err := io.Copy(h, f); err != nil {
-		return "", err
+// hasValidVersion reports whether any entry has a usable version.
+func hasValidVersion(entries []release.Entry) bool {
+	for _, e := range entries {
+		if _, err := release.ParseVersion(e.Version); err == nil {
+			return true
+		}
 	}
-	return hex.EncodeToString(h.Sum(nil)), nil
+	return false
 }
 
-func ensureRemoteDir(hostPort, user, remotePath string) error {
-	host, port := parseHostPort(hostPort)
-	args := []string{}
-	if port != "" {
-		args = append(args, "-p", port)
+// dockerBuildCommand runs bash with args in a throwaway container of image.
+// The parent of the working directory is mounted at the same relative
+// place, so ../RelayClient and the artifacts the script writes there are
+// shared with the host. The container runs as the current user so the
+// artifacts do not end up owned by root, and gets env but not the rest of
+// our environment.
+func dockerBuildCommand(ctx context.Context, image string, args, env []string) (*exec.Cmd, error) {
+	wd, err := os.Getwd()
+	if err != nil {
+		return nil, err
 	}
-	args = append(args, fmt.Sprintf("%s@%s", user, host), "mkdir -p "+remotePath)
-	cmd := exec.Command("ssh", args...)
-	cmd.Stdin = os.Stdin
-	cmd.Stdout = os.Stdout
-	cmd.Stderr = os.Stderr
-	return cmd.Run()
+	root := filepath.Dir(wd)
+	dockerArgs := []string{"run", "--rm",
+		"-v", root + ":/work",
+		"-w", "/work/" + filepath.Base(wd),
+	}
+	if uid := os.Getuid(); uid >= 0 {
+		dockerArgs = append(dockerArgs, "--user", fmt.Sprintf("%d:%d", uid, os.Getgid()))
+	}
+	for _, kv := range env {
+		dockerArgs = append(dockerArgs, "-e", kv)
+	}
+	dockerArgs = append(dockerArgs, image, "bash")
+	return exec.CommandContext(ctx, "docker", append(dockerArgs, args...)...), nil
 }
 
-func uploadWithScp(hostPort, user, remoteDir string, locals ...string) error {
-	host, port := parseHostPort(hostPort)
-	for _, local := range locals {
-		args := []string{}
-		if port != "" {
-			args = append(args, "-P", port)
+// runBuild runs RunBuildAll with the -build-* options, retrying failed
+// builds up to -build-retries times. A missing script or interpreter is not
+// worth retrying. If every attempt fails, the end of the last one's output
+// is shown again, since the retries may have scrolled it away.
+func runBuild(ctx context.Context, opts *options, version string, targets []string) error {
+	image := ""
+	if opts.buildInDocker {
+		image = opts.buildImage
+	}
+	// the script gets the exact version the manifest will list
+	env := []string{"RELEASE_VERSION=" + version}
+	for _, kv := range opts.buildEnv {
+		env = append(env, strings.ReplaceAll(kv, "{version}", version))
+	}
+	for attempt := 0; ; attempt++ {
+		out := &tailBuffer{max: 8 << 10}
+		err := RunBuildAll(ctx, version, targets, image, env, out)
+		if err == nil {
+			return nil
+		}
+		if ctx.Err() != nil || errors.Is(err, fs.ErrNotExist) || errors.Is(err, exec.ErrNotFound) {
+			return err
 		}
-		args = append(args, local, fmt.Sprintf("%s@%s:%s", user, host, remoteDir))
-		cmd := exec.Command("scp", args...)
-		cmd.Stdin = os.Stdin
-		cmd.Stdout = os.Stdout
-		cmd.Stderr = os.Stderr
-		if err := cmd.Run(); err != nil {
-			return fmt.Errorf("scp %s failed: %w", local, err)
+		if attempt == opts.buildRetries {
+			if opts.buildRetries > 0 {
+				fmt.Fprintf(os.Stderr, "--- end of the output of the last build attempt ---\n%s--- build failed %d times ---\n", out.lines(), attempt+1)
+			}
+			return err
+		}
+		fmt.Fprintf(os.Stderr, "warning: %v; retrying (%d of %d)\n", err, attempt+1, opts.buildRetries)
+		select {
+		case <-time.After(opts.buildDelay):
+		case <-ctx.Done():
+			return ctx.Err()
 		}
 	}
-	return nil
 }
 
-func parseHostPort(hp string) (host, port string) {
-	parts := strings.Split(hp, ":")
-	if len(parts) == 2 {
-		return parts[0], parts[1]
-	}
-	return hp, ""
+// tailBuffer keeps the last max bytes written to it.
+type tailBuffer struct {
+	mu  sync.Mutex
+	max int
+	buf []byte
 }
 
-// updateLatestFileSymlinks SSH’s into the server and for each versioned file
-// creates/updates a root‑level symlink pointing to the versioned path.
-func updateLatestFileSymlinks(hostPort, user, remoteBase, newVersion string, files []string) error {
-	host, port := parseHostPort(hostPort)
-
-	for _, f := range files {
-		// strip "-<version>.zip" → get "client.zip"
-		generic := strings.TrimSuffix(f, "-"+newVersion+".zip") + "-latest.zip"
-		target := filepath.Join(remoteBase, newVersion, f) // e.g. /.../0.2.5/client-0.2.5.zip
-		link := filepath.Join(remoteBase, generic)         // e.g. /.../client.zip
-
-		// build: ssh [-p port] user@host "ln -sfn <target> <link>"
-		args := []string{}
-		if port != "" {
-			args = append(args, "-p", port)
-		}
-		args = append(args,
-			fmt.Sprintf("%s@%s", user, host),
-			fmt.Sprintf("ln -sfn %q %q", target, link),
-		)
-		cmd := exec.Command("ssh", args...)
-		cmd.Stdin = os.Stdin
-		cmd.Stdout = os.Stdout
-		cmd.Stderr = os.Stderr
-
-		if err := cmd.Run(); err != nil {
-			return fmt.Errorf("updating symlink for %s: %w", f, err)
-		}
+func (t *tailBuffer) Write(p []byte) (int, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.buf = append(t.buf, p...)
+	if over := len(t.buf) - t.max; over > 0 {
+		t.buf = t.buf[over:]
 	}
-	return nil
+	return len(p), nil
 }
 
-func upsertEntry(entries []Entry, newEntry Entry) []Entry {
-	for i, e := range entries {
-		if e.Version == newEntry.Version {
-			entries[i] = newEntry
-			return entries
+// lines returns what is kept, starting at a whole line and ending in a
+// newline.
+func (t *tailBuffer) lines() string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	s := string(t.buf)
+	if len(t.buf) == t.max {
+		if i := strings.IndexByte(s, '\n'); i >= 0 {
+			s = s[i+1:]
 		}
 	}
-	return append(entries, newEntry)
+	if s != "" && !strings.HasSuffix(s, "\n") {
+		s += "\n"
+	}
+	return s
 }
 
-func RunBuildAll(version string) error {
+// RunBuildAll runs build-all.sh for version. Any targets are passed to the
+// script as extra arguments after the version; none means build everything.
+// With an image, the script runs in a container of it instead (see
+// dockerBuildCommand). env is added to the script's environment. If out is
+// set, the build's output is copied to it as well.
+func RunBuildAll(ctx context.Context, version string, targets []string, image string, env []string, out io.Writer) error {
 	script := "../RelayClient/build/build-all.sh"
 
 	// verify the script exists
@@ -331,12 +2891,26 @@ func RunBuildAll(version string) error {
 		return fmt.Errorf("cannot find script %q: %w", script, err)
 	}
 
-	// use bash to run the script and pass the version arg
-	cmd := exec.Command("bash", script, version)
+	// use bash to run the script and pass the version (and targets) args
+	args := append([]string{script, version}, targets...)
+	cmd := exec.CommandContext(ctx, "bash", args...)
+	cmd.Env = append(os.Environ(), env...)
 	cmd.Stdout = os.Stdout
+	if image != "" {
+		var err error
+		if cmd, err = dockerBuildCommand(ctx, image, args, env); err != nil {
+			return err
+		}
+		// keep stdout for our own report; the container log goes to stderr
+		cmd.Stdout = os.Stderr
+	}
 	cmd.Stderr = os.Stderr
+	if out != nil {
+		cmd.Stdout = io.MultiWriter(cmd.Stdout, out)
+		cmd.Stderr = io.MultiWriter(cmd.Stderr, out)
+	}
 
-	if err := cmd.Run(); err != nil {
+	if err := release.RunCommand(ctx, cmd); err != nil {
 		return fmt.Errorf("build-all.sh failed: %w", err)
 	}
 	return nil
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"relayUpdater/release"
+)
+
+func TestMatchTarget(t *testing.T) {
+	for _, c := range []struct {
+		name    string
+		targets []string
+		want    string
+	}{
+		{"M45-Relay-Client-Linux", []string{"linux"}, "linux"},
+		{"client_win", []string{"linux", "win"}, "win"},
+		{"client-linux-arm64", []string{"linux-arm64"}, "linux-arm64"},
+		{"client-linux-arm64", []string{"linux", "linux-arm64"}, "linux-arm64"},
+		{"client-linux-arm64", []string{"linux-arm64", "linux"}, "linux-arm64"},
+		{"client-linux-amd64", []string{"linux", "linux-arm64"}, "linux"},
+		{"client-1.0.0-win.zip", []string{"win"}, "win"},
+		{"client-Linux_ARM64", []string{"linux-arm64"}, ""},
+		// only whole words count
+		{"client-darwin", []string{"win"}, ""},
+		{"client-linuxmint", []string{"linux"}, ""},
+		{"client-linux-arm", []string{"linux-arm64"}, ""},
+		{"client-linux", nil, ""},
+	} {
+		if got := matchTarget(c.name, c.targets); got != c.want {
+			t.Errorf("matchTarget(%q, %q) = %q, want %q", c.name, c.targets, got, c.want)
+		}
+	}
+}
+
+// TestReplaceArtifact replaces the linux file of 1.0.0 on a local "server"
+// whose build-all.sh records what it was asked to build.
+func TestReplaceArtifact(t *testing.T) {
+	root := t.TempDir()
+	work, remoteDir := filepath.Join(root, "work"), filepath.Join(root, "server")
+	srcDir := filepath.Join(root, "RelayClient")
+	for _, d := range []string{work, filepath.Join(srcDir, "build")} {
+		if err := os.MkdirAll(d, 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	buildAll := "#!/bin/sh\necho \"$@\" > ../build-args\nshift\nfor t; do echo rebuilt > ../RelayClient/client-$t.zip; done\n"
+	if err := os.WriteFile(filepath.Join(srcDir, "build", "build-all.sh"), []byte(buildAll), 0755); err != nil {
+		t.Fatal(err)
+	}
+	// a stale win zip that must not be collected
+	if err := os.WriteFile(filepath.Join(srcDir, "client-win.zip"), []byte("stale"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	t.Chdir(work)
+
+	// 1.0.0 as released, here and on the server
+	var links []release.DownloadInfo
+	for _, name := range []string{"client-linux-1.0.0.zip", "client-win-1.0.0.zip"} {
+		link := filepath.Join(dlDir, "1.0.0", name)
+		for _, dir := range []string{".", remoteDir} {
+			if err := os.MkdirAll(filepath.Join(dir, dlDir, "1.0.0"), 0755); err != nil {
+				t.Fatal(err)
+			}
+			if err := os.WriteFile(filepath.Join(dir, link), []byte("1.0.0"), 0644); err != nil {
+				t.Fatal(err)
+			}
+		}
+		links = append(links, release.DownloadInfo{Link: link, Checksum: name, Size: 5})
+	}
+	entries := []release.Entry{{Version: "1.0.0", Links: links}}
+	win := links[1].Link
+	// the aliases as the release of 1.0.0 left them
+	winAlias := filepath.Join(remoteDir, dlDir, "client-win-latest.zip")
+	if err := os.Symlink(filepath.Join(remoteDir, win), winAlias); err != nil {
+		t.Fatal(err)
+	}
+
+	opts := &options{
+		jsonName:        "relayClient.json",
+		srcDir:          srcDir,
+		targets:         "linux,win",
+		collision:       "overwrite",
+		format:          "json",
+		latestMode:      "symlink",
+		linkStyle:       "absolute",
+		verifyLinks:     "fail",
+		linkFailure:     "fail",
+		remoteTool:      "sha256sum",
+		mirrors:         []mirror{{dir: remoteDir}},
+		replaceArtifact: "1.0.0",
+		replaceFile:     "client-linux-1.0.0.zip",
+		manifest:        release.ManifestOptions{Indent: "  ", ChecksumKeys: []string{"sha256"}},
+	}
+	remote := &release.LocalTransport{Stdout: io.Discard, Stderr: io.Discard}
+	if err := replaceArtifact(context.Background(), opts, remote, entries); err != nil {
+		t.Fatal(err)
+	}
+
+	if b, err := os.ReadFile(filepath.Join(root, "build-args")); err != nil || strings.TrimSpace(string(b)) != "1.0.0 linux" {
+		t.Errorf("build-all.sh ran with %q, %v; want only the linux target", b, err)
+	}
+	sum := sha256.Sum256([]byte("rebuilt\n"))
+	if l := entries[0].Links[0]; l.Checksum != hex.EncodeToString(sum[:]) || l.Size != int64(len("rebuilt\n")) {
+		t.Errorf("replaced link = %+v, want the checksum and size of the rebuilt file", l)
+	}
+	if l := entries[0].Links[1]; l.Checksum != "client-win-1.0.0.zip" || l.Size != 5 {
+		t.Errorf("win link = %+v, want it untouched", l)
+	}
+	for _, dir := range []string{".", remoteDir} {
+		if b, err := os.ReadFile(filepath.Join(dir, entries[0].Links[0].Link)); err != nil || string(b) != "rebuilt\n" {
+			t.Errorf("%s in %s = %q, %v; want the rebuilt file", entries[0].Links[0].Link, dir, b, err)
+		}
+	}
+	if b, err := os.ReadFile(filepath.Join(remoteDir, win)); err != nil || string(b) != "1.0.0" {
+		t.Errorf("win artifact on the server = %q, %v; want it untouched", b, err)
+	}
+	if b, err := os.ReadFile(filepath.Join(remoteDir, dlDir, "client-linux-latest.zip")); err != nil || string(b) != "rebuilt\n" {
+		t.Errorf("linux alias = %q, %v; want it to serve the rebuilt file", b, err)
+	}
+	if target, err := os.Readlink(winAlias); err != nil || target != filepath.Join(remoteDir, win) {
+		t.Errorf("win alias = %q, %v; want it untouched", target, err)
+	}
+	if b, err := os.ReadFile(filepath.Join(remoteDir, opts.jsonName)); err != nil || !strings.Contains(string(b), entries[0].Links[0].Checksum) {
+		t.Errorf("manifest on the server does not list the new checksum: %v\n%s", err, b)
+	}
+}
+
+func TestMissingTargets(t *testing.T) {
+	files := []string{"client-linux-arm64.zip", "client-win.zip"}
+	got := missingTargets(files, []string{"linux", "linux-arm64", "win"})
+	if len(got) != 1 || got[0] != "linux" {
+		t.Errorf("missingTargets = %q, want [linux]", got)
+	}
+}
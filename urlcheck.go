@@ -0,0 +1,76 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"relayUpdater/release"
+)
+
+// urlCheckTimeout bounds each HEAD request of -verify-urls.
+const urlCheckTimeout = 30 * time.Second
+
+// verifyURLs is -verify-urls: it sends an HTTP HEAD for every link on every
+// -base-url and prints one line per URL. A URL passes if it answers 200
+// (after redirects) with the recorded size as its Content-Length. A server
+// that sends no length is trusted on the status alone. This catches web
+// server mistakes an ssh upload cannot see, such as a wrong document root
+// or a denied downloads/ directory.
+func verifyURLs(ctx context.Context, opts *options, links []release.DownloadInfo) error {
+	client, err := httpClient(opts)
+	if err != nil {
+		return err
+	}
+	var failed []string
+	n := 0
+	for _, base := range opts.baseURLs {
+		for _, l := range links {
+			u := release.JoinURL(base, l.Link)
+			n++
+			status, err := headURL(ctx, client, u, l.Size)
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			if err != nil {
+				fmt.Printf("FAIL %s: %v\n", u, err)
+				failed = append(failed, u)
+				continue
+			}
+			fmt.Printf("ok   %s (%s)\n", u, status)
+		}
+	}
+	if len(failed) > 0 {
+		return &release.UploadError{Err: fmt.Errorf("-verify-urls: %d of %d URL(s) are not served as uploaded:\n  %s", len(failed), n, strings.Join(failed, "\n  "))}
+	}
+	return nil
+}
+
+// headURL sends a HEAD request for u and checks the status and, if the
+// server sends one and size is known, the Content-Length. It returns a
+// short description of what was served.
+func headURL(ctx context.Context, client *http.Client, u string, size int64) (string, error) {
+	ctx, cancel := context.WithTimeout(ctx, urlCheckTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodHead, u, nil)
+	if err != nil {
+		return "", err
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("status %s", resp.Status)
+	}
+	if resp.ContentLength < 0 {
+		return "200, no Content-Length", nil
+	}
+	if size > 0 && resp.ContentLength != size {
+		return "", fmt.Errorf("Content-Length is %d, want %d", resp.ContentLength, size)
+	}
+	return fmt.Sprintf("200, %d bytes", resp.ContentLength), nil
+}